@@ -0,0 +1,586 @@
+// Copyright 2020 Georgios Theodorou
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gthd/goawk/parser"
+)
+
+type token struct {
+	kind  byte // 'i' identifier, 'n' number, 's' string, 'r' regular expression, 'o' operator
+	value string
+	pos   int // the offset of the token in the source
+}
+
+// Splits awk source, as printed by the parser, into tokens. Newlines and semicolons are returned as ";".
+func tokenize(src string) []token {
+	var tokens []token
+	operand := false // whether the previous token ends an operand, in which case / is a division
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+			continue
+		case c == '\\' && i+1 < len(src) && src[i+1] == '\n':
+			// a line continuation
+			i += 2
+			continue
+		case c == '\n' || c == ';':
+			tokens = append(tokens, token{'o', ";", i})
+			i++
+			operand = false
+			continue
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '"' || c == '/' && !operand:
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			kind := byte('s')
+			if c == '/' {
+				kind = 'r'
+			}
+			if j >= len(src) {
+				j = len(src) - 1
+			}
+			tokens = append(tokens, token{kind, src[i : j+1], i})
+			i = j + 1
+			operand = true
+			continue
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			word := src[i:j]
+			tokens = append(tokens, token{'i', word, i})
+			i = j
+			operand = !awkKeywords[word] || word == "getline"
+			continue
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.' || src[j] == 'e' || src[j] == 'E' ||
+				(src[j] == '+' || src[j] == '-') && (src[j-1] == 'e' || src[j-1] == 'E')) {
+				j++
+			}
+			tokens = append(tokens, token{'n', src[i:j], i})
+			i = j
+			operand = true
+			continue
+		}
+
+		op := string(c)
+		for _, long := range []string{"++", "--", "+=", "-=", "*=", "/=", "%=", "^=", "==", "!=", "<=", ">=", ">>", "&&", "||", "!~", "**"} {
+			if strings.HasPrefix(src[i:], long) {
+				op = long
+				break
+			}
+		}
+		tokens = append(tokens, token{'o', op, i})
+		i += len(op)
+		operand = op == ")" || op == "]" || op == "$" || op == "++" || op == "--"
+	}
+	return tokens
+}
+
+var awkKeywords = map[string]bool{
+	"BEGIN": true, "END": true, "function": true, "func": true, "if": true, "else": true, "while": true,
+	"for": true, "do": true, "break": true, "continue": true, "next": true, "nextfile": true, "exit": true,
+	"return": true, "delete": true, "in": true, "getline": true, "print": true, "printf": true,
+}
+
+// Identifiers whose value or effect depends on the records read before the current one, or on
+// anything other than the current record, so a program using them cannot process chunks independently.
+// FILENAME is one of them, since the threads read their chunks from their standard input.
+var crossRecord = map[string]bool{
+	"NR": true, "FNR": true, "FILENAME": true, "getline": true, "rand": true, "srand": true, "system": true,
+	"close": true, "fflush": true, "exit": true, "nextfile": true, "delete": true,
+}
+
+var assignmentOps = map[string]bool{
+	"=": true, "+=": true, "-=": true, "*=": true, "/=": true, "%=": true, "^=": true, "++": true, "--": true,
+}
+
+// Checks whether an expression only reads the current record and variables that the actions never assign,
+// so that it gives the same value whichever chunk the record belongs to
+func isPureExpr(src string) bool {
+	for i, t := range tokenize(src) {
+		switch {
+		case t.kind == 'i' && crossRecord[t.value]:
+			return false
+		case t.kind == 'o' && assignmentOps[t.value]:
+			return false
+		case t.kind == 'i' && (t.value == "sub" || t.value == "gsub" || t.value == "split"):
+			// they assign their target
+			return false
+		case t.kind == 'o' && t.value == "[" && i > 0:
+			// arrays may be filled by earlier records
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the BEGIN statements that do not produce output, which are executed by every thread so that
+// the variables they set are available to the actions and to END
+func quietBegin(prog *parser.Program) string {
+	return beginExcept(prog, nil)
+}
+
+// Returns the BEGIN blocks without their print statements, as quietBegin, and without the statements that
+// mention any of the given variables, so that END, executed on its own after the reduction, sees the other
+// variables that BEGIN sets
+func beginExcept(prog *parser.Program, names []string) string {
+	var src strings.Builder
+	for _, block := range prog.Begin {
+		src.WriteString("BEGIN {\n")
+	statements:
+		for _, stmt := range block {
+			tokens := tokenize(stmt.String())
+			if isOutputStmt(stmt.String()) {
+				continue
+			}
+			for _, name := range names {
+				if mentions(tokens, name) {
+					continue statements
+				}
+			}
+			src.WriteString(stmt.String() + "\n")
+		}
+		src.WriteString("}\n")
+	}
+	return src.String()
+}
+
+// Checks whether a statement is a print or printf statement
+func isOutputStmt(src string) bool {
+	tokens := tokenize(src)
+	return len(tokens) > 0 && tokens[0].kind == 'i' && (tokens[0].value == "print" || tokens[0].value == "printf")
+}
+
+// Checks whether a token has a side effect other than assigning variables. rand() and srand() are among them,
+// as every thread executing them would draw the same numbers as the others.
+func isSideEffect(t token) bool {
+	return t.kind == 'i' && (t.value == "print" || t.value == "printf" || t.value == "getline" ||
+		t.value == "system" || t.value == "close" || t.value == "fflush" || t.value == "exit" ||
+		t.value == "rand" || t.value == "srand")
+}
+
+// Checks whether a token depends on the current record
+func isRecordDependent(t token) bool {
+	return t.kind == 'i' && (crossRecord[t.value] || t.value == "NF") || t.kind == 'o' && t.value == "$"
+}
+
+// Returns the names of the functions whose body, or the body of a function they call, has a token matching used
+func functionsUsing(prog *parser.Program, used func(token) bool) map[string]bool {
+	bodies := make(map[string][]token)
+	for _, f := range prog.Functions {
+		bodies[f.Name] = tokenize(f.Body.String())
+	}
+	found := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for name, body := range bodies {
+			if found[name] {
+				continue
+			}
+			for _, t := range body {
+				if used(t) || t.kind == 'i' && found[t.value] {
+					found[name], changed = true, true
+					break
+				}
+			}
+		}
+	}
+	return found
+}
+
+// Checks whether BEGIN can be executed once for its output and again by every thread for its variables:
+// apart from its top-level print statements, which are dropped for the threads, it must not have any side effects,
+// either directly or through the functions it calls
+func isQuietBegin(prog *parser.Program) bool {
+	noisy := functionsUsing(prog, isSideEffect)
+	for _, block := range prog.Begin {
+		for _, stmt := range block {
+			src := stmt.String()
+			if isOutputStmt(src) {
+				continue
+			}
+			for _, t := range tokenize(src) {
+				if isSideEffect(t) || t.kind == 'i' && noisy[t.value] {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// Checks whether the tokens modify a variable, by assigning it, reading it with getline or as the target
+// of sub() and gsub()
+func modifies(tokens []token, name string) bool {
+	if assignedVars(tokens)[name] {
+		return true
+	}
+	for i, t := range tokens {
+		if t.kind != 'i' || i+1 >= len(tokens) {
+			continue
+		}
+		if t.value == "getline" && tokens[i+1].value == name {
+			return true
+		}
+		if t.value == "sub" || t.value == "gsub" {
+			if target := subTarget(tokens[i+1:]); target >= 0 && tokens[i+1+target].value == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the value of a string literal as printed by the parser
+func stringLiteral(literal string) string {
+	if s, err := strconv.Unquote(literal); err == nil {
+		return s
+	}
+	return unescape(literal[1 : len(literal)-1])
+}
+
+// Returns the value that BEGIN gives a variable such as RS by assigning it a string literal in its top-level
+// statements, as in BEGIN { RS = "" }, and whether it is assigned at all. constant is false when BEGIN,
+// an action or a function modifies the variable in any other way, so that its value is only known as the
+// program runs.
+func beginConstant(prog *parser.Program, name string) (value string, assigned bool, constant bool) {
+	constant = true
+	for _, block := range prog.Begin {
+		for _, stmt := range block {
+			tokens := tokenize(stmt.String())
+			if len(tokens) == 3 && tokens[0].value == name && tokens[1].value == "=" && tokens[2].kind == 's' {
+				value, assigned = stringLiteral(tokens[2].value), true
+			} else if modifies(tokens, name) {
+				assigned, constant = true, false
+			}
+		}
+	}
+	var sources []string
+	for _, action := range prog.Actions {
+		sources = append(sources, action.Stmts.String())
+	}
+	for _, f := range prog.Functions {
+		sources = append(sources, f.Body.String())
+	}
+	for _, src := range sources {
+		if modifies(tokenize(src), name) {
+			assigned, constant = true, false
+		}
+	}
+	return value, assigned, constant
+}
+
+// Checks whether a pattern or action has a token matching used, directly or through the functions it calls
+func actionsUsing(prog *parser.Program, used func(token) bool) bool {
+	functions := functionsUsing(prog, used)
+	for _, action := range prog.Actions {
+		src := action.Stmts.String()
+		for _, pattern := range action.Pattern {
+			src += "\n" + pattern.String()
+		}
+		for _, t := range tokenize(src) {
+			if used(t) || t.kind == 'i' && functions[t.value] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Checks whether a pattern or action uses an identifier of crossRecord, which would only see the chunk
+// of the standard input that the thread executing it reads
+func usesCrossRecord(prog *parser.Program) bool {
+	return actionsUsing(prog, func(t token) bool { return t.kind == 'i' && crossRecord[t.value] })
+}
+
+// Checks whether a pattern or action calls a function that does not satisfy isLocalFunction, such as one
+// assigning global variables, whose values would differ between the threads
+func callsStatefulFunction(prog *parser.Program) bool {
+	stateful := make(map[string]bool)
+	for _, f := range prog.Functions {
+		stateful[f.Name] = !isLocalFunction(f.Params, tokenize(f.Body.String()))
+	}
+	return actionsUsing(prog, func(t token) bool { return t.kind == 'i' && stateful[t.value] })
+}
+
+// Checks whether END can be executed once after the output of the records: it must not depend on the
+// records, either directly, through the functions it calls or through variables assigned by the actions
+func isIndependentEnd(prog *parser.Program, assigned map[string]bool) bool {
+	dependent := functionsUsing(prog, isRecordDependent)
+	for _, block := range prog.End {
+		for _, t := range tokenize(block.String()) {
+			if isRecordDependent(t) || t.kind == 'i' && (assigned[t.value] || dependent[t.value]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Built-in variables that keep their value from one record to the next, and so cannot be assigned by the actions
+var crossRecordVars = map[string]bool{
+	"FS": true, "OFS": true, "ORS": true, "RS": true, "SUBSEP": true, "CONVFMT": true, "OFMT": true,
+	"RSTART": true, "RLENGTH": true, "FILENAME": true, "ENVIRON": true, "ARGC": true, "ARGV": true,
+}
+
+// Checks whether the statements of an action only depend on the current record: they must not use the
+// identifiers of crossRecord, RSTART and RLENGTH which may have been set by match() for an earlier record,
+// arrays, or split(), sub() and gsub() on anything but a field
+func isLocalStmts(tokens []token) bool {
+	for i, t := range tokens {
+		switch {
+		case t.kind == 'i' && (crossRecord[t.value] || t.value == "RSTART" || t.value == "RLENGTH" || t.value == "split"):
+			return false
+		case t.kind == 'o' && t.value == "[":
+			return false
+		case t.kind == 'i' && (t.value == "sub" || t.value == "gsub"):
+			if target := subTarget(tokens[i+1:]); target >= 0 && tokens[i+1+target].value != "$" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Returns the index in tokens, which follow sub or gsub, of the first token of their third argument,
+// or -1 when they are called without one and change $0
+func subTarget(tokens []token) int {
+	depth, commas := 0, 0
+	for i, t := range tokens {
+		switch {
+		case t.kind != 'o':
+		case t.value == "(" || t.value == "[" || t.value == "{":
+			depth++
+		case t.value == ")" || t.value == "]" || t.value == "}":
+			depth--
+			if depth == 0 {
+				return -1
+			}
+		case t.value == "," && depth == 1:
+			commas++
+			if commas == 2 && i+1 < len(tokens) {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// Returns the variables assigned in the tokens, leaving out the fields such as $i in $i = 1 or $i++
+func assignedVars(tokens []token) map[string]bool {
+	assigned := make(map[string]bool)
+	for i, t := range tokens {
+		if t.kind != 'i' || awkKeywords[t.value] || i > 0 && tokens[i-1].value == "$" {
+			continue
+		}
+		if i+1 < len(tokens) && tokens[i+1].kind == 'o' && assignmentOps[tokens[i+1].value] ||
+			i > 0 && tokens[i-1].kind == 'o' && (tokens[i-1].value == "++" || tokens[i-1].value == "--") {
+			assigned[t.value] = true
+		}
+	}
+	return assigned
+}
+
+// Checks whether the tokens mention the identifier
+func mentions(tokens []token, name string) bool {
+	for _, t := range tokens {
+		if t.kind == 'i' && t.value == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks whether a variable assigned by an action is set anew for every record before it is read. Every
+// top-level statement of the action that mentions it must either use it only as the variable of for loops
+// that initialize it, as in for (i = 1; i <= NF; i++), or be a plain assignment whose value does not depend
+// on it, after which the following statements may use it freely.
+func isLocalVar(stmts []string, name string) bool {
+	for _, stmt := range stmts {
+		tokens := tokenize(stmt)
+		if !mentions(tokens, name) || isLoopVar(tokens, name) {
+			continue
+		}
+		return len(tokens) > 2 && tokens[0].value == name && tokens[1].value == "=" && !mentions(tokens[2:], name)
+	}
+	return true
+}
+
+// Checks whether the tokens only mention the variable within for loops whose initialization assigns it
+// a value that does not depend on it
+func isLoopVar(tokens []token, name string) bool {
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i].kind != 'i' || tokens[i].value != name {
+			continue
+		}
+		if i < 2 || tokens[i-2].value != "for" || tokens[i-1].value != "(" || i+1 >= len(tokens) || tokens[i+1].value != "=" {
+			return false
+		}
+		// the initialization ends at the first semicolon
+		j := i + 2
+		for j < len(tokens) && tokens[j].value != ";" {
+			if tokens[j].kind == 'i' && tokens[j].value == name {
+				return false
+			}
+			j++
+		}
+		i = statementEnd(tokens, i-2)
+	}
+	return true
+}
+
+// Returns the index of the last token of the statement starting with a keyword followed by a parenthesized
+// header, such as for or while, whose body is either a block or a single statement
+func statementEnd(tokens []token, start int) int {
+	i, depth := start+1, 0
+	for ; i < len(tokens); i++ {
+		if tokens[i].value == "(" {
+			depth++
+		} else if tokens[i].value == ")" {
+			depth--
+			if depth == 0 {
+				break
+			}
+		}
+	}
+	i++
+	for i < len(tokens) && tokens[i].value == ";" {
+		i++
+	}
+	if i < len(tokens) && tokens[i].value == "{" {
+		depth = 0
+		for ; i < len(tokens); i++ {
+			if tokens[i].value == "{" {
+				depth++
+			} else if tokens[i].value == "}" {
+				depth--
+				if depth == 0 {
+					return i
+				}
+			}
+		}
+		return i
+	}
+	for i < len(tokens) && tokens[i].value != ";" && tokens[i].value != "}" {
+		i++
+	}
+	return i
+}
+
+// Checks whether a function only depends on the current record and its arguments: its body must follow the
+// rules of isLocalStmts and only assign its parameters, which are local to every call
+func isLocalFunction(params []string, body []token) bool {
+	if !isLocalStmts(body) {
+		return false
+	}
+	local := make(map[string]bool)
+	for _, param := range params {
+		local[param] = true
+	}
+	for name := range assignedVars(body) {
+		if !local[name] && name != "NF" {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the source of the functions of a program, which are part of every program executed for it
+func functionSource(prog *parser.Program) string {
+	var src strings.Builder
+	for _, f := range prog.Functions {
+		src.WriteString("function " + f.Name + "(" + strings.Join(f.Params, ", ") + ") {\n" + f.Body.String() + "}\n")
+	}
+	return src.String()
+}
+
+// Checks whether every record can be processed on its own, independently of the records before it,
+// in which case the chunks can be processed in parallel and their output concatenated in input order.
+// Filters, transformations such as { $2 = length($2); print } and loops over the fields of the record are such
+// programs, as long as the variables assigned by an action are set for every record before they are read,
+// and only used within that action. The functions they call must not assign global variables.
+func isRecordLocal(prog *parser.Program) bool {
+	if len(prog.Actions) == 0 || !isQuietBegin(prog) {
+		return false
+	}
+	var functions []token
+	for _, f := range prog.Functions {
+		body := tokenize(f.Body.String())
+		if !isLocalFunction(f.Params, body) {
+			return false
+		}
+		functions = append(functions, body...)
+	}
+	var patterns []token
+	actions := make([][]token, len(prog.Actions))
+	for i, action := range prog.Actions {
+		// a range pattern remembers whether its start has been matched by an earlier record
+		if len(action.Pattern) > 1 {
+			return false
+		}
+		for _, pattern := range action.Pattern {
+			if !isPureExpr(pattern.String()) {
+				return false
+			}
+			patterns = append(patterns, tokenize(pattern.String())...)
+		}
+		actions[i] = tokenize(action.Stmts.String())
+		if !isLocalStmts(actions[i]) {
+			return false
+		}
+	}
+
+	assigned := make(map[string]bool)
+	for i, action := range prog.Actions {
+		var stmts []string
+		for _, stmt := range action.Stmts {
+			stmts = append(stmts, stmt.String())
+		}
+		for name := range assignedVars(actions[i]) {
+			if name == "NF" {
+				// NF is set anew by every record
+				continue
+			}
+			if crossRecordVars[name] || mentions(patterns, name) || mentions(functions, name) || !isLocalVar(stmts, name) {
+				return false
+			}
+			for j := range actions {
+				if j != i && mentions(actions[j], name) {
+					return false
+				}
+			}
+			assigned[name] = true
+		}
+	}
+	return isIndependentEnd(prog, assigned)
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 Georgios Theodorou
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var (
+	benchSize    = flag.Int64("bench-size", 64<<20, "the size in bytes of the data generated for the benchmarks")
+	benchThreads = flag.Int("bench-threads", 0, "the maximum number of threads benchmarked, by default the available cores")
+
+	benchData     string
+	benchDataOnce sync.Once
+)
+
+// Returns the programs benchmarked, all those in benchmark/, including the ones printing every record
+// and those executed in one thread, so that their cost is measured too
+func benchPrograms(b *testing.B) []string {
+	progs, err := filepath.Glob(filepath.Join("benchmark", "*"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return progs
+}
+
+// Runs pawk with the given arguments, discarding its output, which would otherwise be held in memory
+func runBenchmark(b *testing.B, args []string) *os.ProcessState {
+	cmd := exec.Command(pawkPath, args...)
+	cmd.Stdout = ioutil.Discard
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		b.Fatalf("pawk: %v\n%s", err, stderr.Bytes())
+	}
+	return cmd.ProcessState
+}
+
+// Returns the data file shared by all benchmarks, generating it the first time it is needed
+func benchmarkData(b *testing.B) string {
+	benchDataOnce.Do(func() {
+		benchData = filepath.Join(filepath.Dir(pawkPath), "data.txt")
+		f, err := os.Create(benchData)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer f.Close()
+		schema := defaultGenSchema()
+		schema.size = *benchSize
+		if err := generate(f, schema); err != nil {
+			b.Fatal(err)
+		}
+	})
+	return benchData
+}
+
+// Runs every benchmark program with 1 up to the maximum number of threads, reporting the throughput
+// over the generated data and the speedup over the run with one thread
+func BenchmarkPrograms(b *testing.B) {
+	data := benchmarkData(b)
+	maxThreads := *benchThreads
+	if maxThreads <= 0 {
+		maxThreads = getNumCores()
+	}
+
+	for _, prog := range benchPrograms(b) {
+		name := filepath.Base(prog)
+		var baseline time.Duration
+		for threads := 1; threads <= maxThreads; threads++ {
+			b.Run(name+"/n="+strconv.Itoa(threads), func(b *testing.B) {
+				b.SetBytes(*benchSize)
+				for i := 0; i < b.N; i++ {
+					runBenchmark(b, []string{"-n", strconv.Itoa(threads), "--oversubscribe", "-f", prog, data})
+				}
+				perRun := b.Elapsed() / time.Duration(b.N)
+				if threads == 1 {
+					baseline = perRun
+				}
+				if baseline > 0 {
+					b.ReportMetric(float64(baseline)/float64(perRun), "speedup")
+				}
+			})
+		}
+	}
+}
+
+// The garbage collection settings compared by BenchmarkGC, the first being pawk's defaults
+var benchGCSettings = [][]string{
+	nil,
+	{"--gc-percent", "50"},
+	{"--gc-percent", "200"},
+	{"--gc-percent", "400"},
+	{"--gc-percent", "off", "--memory-limit", "2GiB"},
+}
+
+// Runs the benchmark programs with the maximum number of threads under different garbage collection settings,
+// reporting the throughput and the peak resident memory of pawk for each
+func BenchmarkGC(b *testing.B) {
+	data := benchmarkData(b)
+	threads := *benchThreads
+	if threads <= 0 {
+		threads = getNumCores()
+	}
+
+	for _, prog := range benchPrograms(b) {
+		name := filepath.Base(prog)
+		for _, setting := range benchGCSettings {
+			label := "default"
+			if setting != nil {
+				label = strings.Join(setting, "=")
+			}
+			b.Run(name+"/"+label, func(b *testing.B) {
+				b.SetBytes(*benchSize)
+				var peak int64
+				for i := 0; i < b.N; i++ {
+					args := append([]string{"-n", strconv.Itoa(threads), "--oversubscribe"}, setting...)
+					state := runBenchmark(b, append(args, "-f", prog, data))
+					// Maxrss is in KiB on Linux
+					if rss := state.SysUsage().(*syscall.Rusage).Maxrss; rss > peak {
+						peak = rss
+					}
+				}
+				b.ReportMetric(float64(peak)/1024, "peak-MiB")
+			})
+		}
+	}
+}
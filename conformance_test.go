@@ -0,0 +1,318 @@
+// Copyright 2020 Georgios Theodorou
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	update    = flag.Bool("update", false, "regenerate the golden files with the reference awk")
+	awkPath   = flag.String("awk", "gawk", "the reference awk used by -update")
+	pawkPath  string
+	threadSet = []int{1, 2, 4, 8}
+)
+
+// Builds pawk once, so that every test runs the binary as a user would
+func TestMain(m *testing.M) {
+	flag.Parse()
+	dir, err := ioutil.TempDir("", "pawk-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	pawkPath = filepath.Join(dir, "pawk")
+	if out, err := exec.Command("go", "build", "-o", pawkPath, ".").CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "building pawk: %v\n%s", err, out)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+type conformanceCase struct {
+	name   string
+	prog   string   // the file containing the awk program
+	input  string   // the input file
+	args   []string // options given both to pawk and to the reference awk
+	golden string   // the file containing the output of the reference awk
+}
+
+// Returns the benchmark programs run over testdata/conformance/input.txt, the testdata/conformance/*.awk
+// programs run over the tab separated countries file and the programs of testdata/conformance/records and
+// testdata/conformance/fixed. These programs are pawk's
+// own, written after the examples that The AWK Programming Language runs over the same file; the test
+// suites of one-true-awk and gawk are not part of them.
+func conformanceCases(t *testing.T) []conformanceCase {
+	var cases []conformanceCase
+	progs, err := filepath.Glob(filepath.Join("benchmark", "tt.*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tt.14 depends on rand(), while stats.txt and minmax.txt use pawk's own min and max
+	progs = append(progs, filepath.Join("benchmark", "sum.txt"))
+	for _, prog := range progs {
+		name := filepath.Base(prog)
+		if name == "tt.14" {
+			continue
+		}
+		cases = append(cases, conformanceCase{
+			name:   "benchmark/" + name,
+			prog:   prog,
+			input:  filepath.Join("testdata", "conformance", "input.txt"),
+			golden: filepath.Join("testdata", "conformance", "benchmark", name+".golden"),
+		})
+	}
+
+	progs, err = filepath.Glob(filepath.Join("testdata", "conformance", "*.awk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, prog := range progs {
+		name := strings.TrimSuffix(filepath.Base(prog), ".awk")
+		cases = append(cases, conformanceCase{
+			name:   name,
+			prog:   prog,
+			input:  filepath.Join("testdata", "conformance", "countries"),
+			args:   []string{"-F", `\t`},
+			golden: filepath.Join("testdata", "conformance", name+".golden"),
+		})
+	}
+
+	// programs setting the record separator in BEGIN, over addresses separated by blank lines, and the field
+	// widths, over fixed-width weather stations
+	for _, dir := range []struct{ name, input string }{{"records", "addresses"}, {"fixed", "stations"}} {
+		progs, err = filepath.Glob(filepath.Join("testdata", "conformance", dir.name, "*.awk"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, prog := range progs {
+			name := strings.TrimSuffix(filepath.Base(prog), ".awk")
+			cases = append(cases, conformanceCase{
+				name:   dir.name + "/" + name,
+				prog:   prog,
+				input:  filepath.Join("testdata", "conformance", dir.name, dir.input),
+				golden: filepath.Join("testdata", "conformance", dir.name, name+".golden"),
+			})
+		}
+	}
+	return cases
+}
+
+// Runs pawk with the given options followed by the program file and the input, returning its standard output
+func runPawk(t *testing.T, options []string, prog string, input string) []byte {
+	t.Helper()
+	args := append(append([]string{}, options...), "-f", prog, input)
+	cmd := exec.Command(pawkPath, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("pawk %s: %v\n%s", strings.Join(args, " "), err, stderr.Bytes())
+	}
+	return out
+}
+
+func TestConformance(t *testing.T) {
+	for _, c := range conformanceCases(t) {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			if *update {
+				args := append(append([]string{}, c.args...), "-f", c.prog, c.input)
+				out, err := exec.Command(*awkPath, args...).Output()
+				if err != nil {
+					t.Fatalf("%s: %v", *awkPath, err)
+				}
+				if err := ioutil.WriteFile(c.golden, out, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(c.golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// chunks smaller than a record, about 16 chunks, so that the chunk boundaries are exercised by every
+			// number of threads, and a single chunk
+			info, err := os.Stat(c.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sizes := []string{"7", strconv.FormatInt(info.Size()/16+1, 10), "1MiB"}
+			for _, size := range sizes {
+				for _, threads := range threadSet {
+					options := append([]string{"-n", strconv.Itoa(threads), "--oversubscribe", "--chunk-size", size}, c.args...)
+					got := runPawk(t, options, c.prog, c.input)
+					if !bytes.Equal(got, want) {
+						t.Errorf("-n %d --chunk-size %s: output differs from %s\ngot:\n%s\nwant:\n%s",
+							threads, size, c.golden, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// Asserts that sums over decimals do not depend on the number of threads, since the chunks, and therefore
+// the partial sums combined by the reduction, do not
+func TestSumsIndependentOfThreads(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "decimals.txt")
+	prog := filepath.Join(dir, "sum.awk")
+	f, err := os.Create(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := defaultGenSchema()
+	schema.size = 256 << 10
+	schema.decimals = 3
+	err = generate(f, schema)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(prog, []byte("{ s += $2; t += $3 / 7 }\nEND { printf \"%.17g %.17g\\n\", s, t }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []string{"ordered", "kahan", "exact"} {
+		options := []string{"--summation", mode, "--chunk-size", "4KiB", "--oversubscribe"}
+		want := runPawk(t, append([]string{"-n", "1"}, options...), prog, input)
+		got := runPawk(t, append([]string{"-n", "8"}, options...), prog, input)
+		if !bytes.Equal(got, want) {
+			t.Errorf("--summation %s: -n 8 gives %s, -n 1 gives %s", mode, got, want)
+		}
+	}
+}
+
+// Asserts that @include finds a file by its name, with or without the .awk suffix, in the directories of
+// AWKPATH and, when AWKPATH is not set, in the current directory
+func TestIncludeSearchPath(t *testing.T) {
+	lib := t.TempDir()
+	dir := t.TempDir()
+	prog := filepath.Join(dir, "prog.awk")
+	input := filepath.Join(dir, "input.txt")
+	files := map[string]string{
+		filepath.Join(lib, "double.awk"): "function double(x) { return 2 * x }\n",
+		prog:                             "@include \"double\"\n{ print double($2) }\n",
+		input:                            "a 1\nb 2\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var env []string
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, "AWKPATH=") {
+			env = append(env, v)
+		}
+	}
+
+	for _, c := range []struct {
+		name string
+		dir  string
+		env  []string
+	}{
+		{"AWKPATH", "", append(append([]string{}, env...), "AWKPATH=/nonexistent:"+lib)},
+		{"default", lib, env},
+	} {
+		cmd := exec.Command(pawkPath, "-f", prog, input)
+		cmd.Dir, cmd.Env = c.dir, c.env
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		got, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", c.name, err, stderr.Bytes())
+		}
+		if want := "2\n4\n"; string(got) != want {
+			t.Errorf("%s: got %q, want %q", c.name, got, want)
+		}
+	}
+}
+
+// Asserts that the variables dumped with -d are those gawk dumps, with their types: strings are quoted,
+// numbers have 17 significant digits, variables never assigned are untyped and arrays show their size.
+// Both a program executed in one thread and a reduction executed in parallel are compared.
+func TestDumpMatchesGawk(t *testing.T) {
+	gawk, err := exec.LookPath("gawk")
+	if err != nil {
+		t.Skip("gawk is not installed")
+	}
+	input := filepath.Join("testdata", "conformance", "countries")
+	programs := map[string]string{
+		"sequential": `BEGIN { label = "total" }
+{ country = $1; area += $2; pop[$4] += $3; n++ }
+END { ratio = area / n; empty = (missing == "") }
+`,
+		"reduction": `BEGIN { label = "total"; scale = 0.1 }
+{ area += $2; pop[$4] += $3 }
+END { density = area * scale / NR }
+`,
+	}
+	compared := map[string][]string{
+		"sequential": {"FS", "NR", "area", "country", "empty", "label", "missing", "n", "pop", "ratio"},
+		"reduction":  {"FS", "NR", "area", "density", "label", "pop", "scale"},
+	}
+
+	// Returns the dumped variables by name
+	parseDump := func(file string) map[string]string {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vars := make(map[string]string)
+		for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+			if colon := strings.Index(line, ": "); colon >= 0 {
+				vars[line[:colon]] = line[colon+2:]
+			}
+		}
+		return vars
+	}
+
+	for name, src := range programs {
+		dir := t.TempDir()
+		prog := filepath.Join(dir, "prog.awk")
+		if err := ioutil.WriteFile(prog, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		wantFile := filepath.Join(dir, "gawk.out")
+		if out, err := exec.Command(gawk, "-F", `\t`, "-d"+wantFile, "-f", prog, input).CombinedOutput(); err != nil {
+			t.Fatalf("%s: gawk: %v\n%s", name, err, out)
+		}
+		want := parseDump(wantFile)
+
+		for _, threads := range []int{1, 4} {
+			gotFile := filepath.Join(dir, "pawk.out")
+			runPawk(t, []string{"-n", strconv.Itoa(threads), "--oversubscribe", "-F", `\t`, "-d" + gotFile}, prog, input)
+			got := parseDump(gotFile)
+			for _, v := range compared[name] {
+				if got[v] != want[v] {
+					t.Errorf("%s -n %d: %s is dumped as %q, gawk dumps %q", name, threads, v, got[v], want[v])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Georgios Theodorou
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Returns the number of CPU cores pawk can use: the cores in the affinity mask of the process, which
+// runtime.NumCPU already honours, further limited by the CPU quota of its cgroup when running inside a container
+func getNumCores() int {
+	cores := runtime.NumCPU()
+	if quota := cgroupCPUQuota(); quota > 0 && quota < cores {
+		cores = quota
+	}
+	return cores
+}
+
+// Sets the number of threads to the number of available cores, unless given from the console
+func setupThreads() {
+	numCores = getNumCores()
+	fmt.Fprintln(os.Stderr, "Number of cores is:", numCores)
+	if numberOfThreads <= 0 {
+		numberOfThreads = numCores
+	} else if numberOfThreads > numCores && !oversubscribe {
+		fmt.Fprintln(os.Stderr, "Number of threads surpasses available CPU cores. Reverting to "+strconv.Itoa(numCores)+" threads. (Equal to the maximum number of CPU cores)")
+		numberOfThreads = numCores
+	}
+}
+
+// Returns the number of cores allowed by the cgroup CPU quota, rounded up, or 0 if there is no quota
+func cgroupCPUQuota() int {
+	// cgroup v2 keeps quota and period in one file, e.g. "200000 100000" or "max 100000"
+	for _, dir := range cgroupDirs("") {
+		if content, err := ioutil.ReadFile(filepath.Join(dir, "cpu.max")); err == nil {
+			fields := strings.Fields(string(content))
+			if len(fields) == 2 && fields[0] != "max" {
+				return quotaCores(fields[0], fields[1])
+			}
+			return 0
+		}
+	}
+
+	// cgroup v1 keeps them in two files, with a quota of -1 meaning no limit
+	for _, dir := range cgroupDirs("cpu") {
+		quota, err := ioutil.ReadFile(filepath.Join(dir, "cpu.cfs_quota_us"))
+		if err != nil {
+			continue
+		}
+		period, err := ioutil.ReadFile(filepath.Join(dir, "cpu.cfs_period_us"))
+		if err != nil {
+			continue
+		}
+		return quotaCores(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+	}
+	return 0
+}
+
+// Returns the directories where the cgroup of the process may be mounted for the given v1 controller,
+// or for the unified v2 hierarchy when controller is empty
+func cgroupDirs(controller string) []string {
+	root := "/sys/fs/cgroup"
+	var dirs []string
+	content, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err == nil {
+		// every line is of the form hierarchy-ID:controller-list:cgroup-path
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			fields := strings.SplitN(line, ":", 3)
+			if len(fields) != 3 {
+				continue
+			}
+			if controller == "" && fields[0] == "0" && fields[1] == "" {
+				dirs = append(dirs, filepath.Join(root, fields[2]))
+			}
+			for _, c := range strings.Split(fields[1], ",") {
+				if controller != "" && c == controller {
+					dirs = append(dirs, filepath.Join(root, fields[1], fields[2]), filepath.Join(root, c, fields[2]))
+				}
+			}
+		}
+	}
+	// inside a container the cgroup of the process is usually mounted as the root of the hierarchy
+	switch controller {
+	case "":
+		return append(dirs, root)
+	case "cpu":
+		return append(dirs, filepath.Join(root, controller), filepath.Join(root, "cpu,cpuacct"))
+	default:
+		return append(dirs, filepath.Join(root, controller))
+	}
+}
+
+func quotaCores(quota string, period string) int {
+	q, err := strconv.ParseInt(quota, 10, 64)
+	if err != nil || q <= 0 {
+		return 0
+	}
+	p, err := strconv.ParseInt(period, 10, 64)
+	if err != nil || p <= 0 {
+		return 0
+	}
+	return int((q + p - 1) / p)
+}
@@ -0,0 +1,367 @@
+// Copyright 2020 Georgios Theodorou
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gthd/goawk/parser"
+)
+
+var (
+	dumpFile   = ""
+	dumpFormat = "text"
+
+	// The global variables of the program and their values when pawk exits, as left by the last program
+	// executed, which is END, or as computed by the reduction when END does not set them. The types and
+	// values recorded by the END block of dumpProbe take precedence over the numbers.
+	dump struct {
+		scalars  map[string]bool
+		arrays   map[string]bool
+		numbers  map[string]float64
+		values   map[string]map[string]float64
+		typed    map[string]dumpedValue
+		elements map[string]map[string]dumpedValue
+		convfmt  string
+		written  bool
+	}
+)
+
+// A value of a variable as the interpreter holds it
+type dumpedValue struct {
+	kind string // untyped, number or string
+	str  string
+	num  float64
+}
+
+// The native functions that the END block of dumpProbe records the variables with
+const (
+	dumpConvfmtFunction = "dump_convfmt"
+	dumpPivotFunction   = "dump_pivot"
+	dumpScalarFunction  = "dump_scalar"
+	dumpElementFunction = "dump_element"
+)
+
+// The built-in variables that are dumped together with those of the program. Those describing the last record,
+// such as NF and FILENAME, are left out, as END is executed on its own after the records.
+var dumpBuiltins = []string{"CONVFMT", "FS", "NR", "OFMT", "OFS", "ORS", "RS", "SUBSEP"}
+
+// Checks the format given with --dump-format
+func checkDumpFormat(format string) {
+	if format != "text" && format != "json" {
+		panic("Unknown dump format: " + format + ". Use text or json")
+	}
+}
+
+// Records the global variables of the program, to be written by writeDump
+func dumpVariables(prog *parser.Program) {
+	dump.scalars = make(map[string]bool)
+	dump.arrays = make(map[string]bool)
+	for name := range prog.Scalars {
+		dump.scalars[name] = true
+	}
+	for name := range prog.Arrays {
+		if name != "ARGV" && name != "ENVIRON" {
+			dump.arrays[name] = true
+		}
+	}
+}
+
+// Returns an END block that records the type and value of every global variable of the program and of the
+// built-in variables, as the interpreter holds them after the END blocks of the program, or nothing when the
+// variables are not dumped. It is added to the last program executed.
+func dumpProbe() string {
+	if dumpFile == "" {
+		return ""
+	}
+	var names, arrays []string
+	for name := range dump.scalars {
+		names = append(names, name)
+	}
+	for name := range dump.arrays {
+		arrays = append(arrays, name)
+	}
+	sort.Strings(names)
+	sort.Strings(arrays)
+
+	// the values are passed as strings and numbers, with the comparisons that tell them apart
+	var src strings.Builder
+	src.WriteString("END {\n\t" + dumpConvfmtFunction + "(CONVFMT)\n")
+	for _, name := range append(dumpBuiltins, names...) {
+		fmt.Fprintf(&src, "\t%s(\"%s\", %s, %s, %s == 0 && %s == \"\", %s < %s(%s, %s), !%s)\n",
+			dumpScalarFunction, name, name, name, name, name, name, dumpPivotFunction, name, name, name)
+	}
+	for _, name := range arrays {
+		e := name + "[dump_key]"
+		fmt.Fprintf(&src, "\tfor (dump_key in %s) %s(\"%s\", dump_key, %s, %s, %s < %s(%s, %s), !%s)\n",
+			name, dumpElementFunction, name, e, e, e, dumpPivotFunction, e, e, e)
+	}
+	src.WriteString("}\n")
+	return src.String()
+}
+
+// Adds to funcs the native functions called by the END block of dumpProbe
+func addDumpFunctions(funcs map[string]interface{}) {
+	if dumpFile == "" {
+		return
+	}
+	dump.typed = make(map[string]dumpedValue)
+	dump.elements = make(map[string]map[string]dumpedValue)
+	funcs[dumpConvfmtFunction] = func(convfmt string) {
+		dump.convfmt = convfmt
+	}
+	funcs[dumpPivotFunction] = func(s string, v float64) float64 {
+		p, _ := dumpPivot(s, v)
+		return p
+	}
+	funcs[dumpScalarFunction] = func(name string, s string, v float64, untyped bool, less bool, not bool) {
+		if untyped {
+			dump.typed[name] = dumpedValue{kind: "untyped"}
+			return
+		}
+		dump.typed[name] = typedValue(name, s, v, less, not)
+	}
+	funcs[dumpElementFunction] = func(name string, key string, s string, v float64, less bool, not bool) {
+		if dump.elements[name] == nil {
+			dump.elements[name] = make(map[string]dumpedValue)
+		}
+		dump.elements[name][key] = typedValue(name+"["+key+"]", s, v, less, not)
+	}
+}
+
+// Converts a number to a string as the interpreter does, integers without CONVFMT
+func convertNumber(v float64) string {
+	if v == float64(int(v)) {
+		return strconv.Itoa(int(v))
+	}
+	return fmt.Sprintf(dump.convfmt, v)
+}
+
+// Returns a number p that a value, given as its string s and its number v, is less than when compared as a
+// number but not when compared as a string, or the other way round, so that the result of comparing it
+// with p tells which of the two it is. There is none for 0, which !x tells apart instead.
+func dumpPivot(s string, v float64) (float64, bool) {
+	candidates := []float64{v - 1, v + 1, 10 * v, v / 10, 1e-05, -1e-05}
+	for k := 1; k <= 9; k++ {
+		candidates = append(candidates, float64(k), -float64(k))
+	}
+	for k := -6; k <= 20; k++ {
+		candidates = append(candidates, math.Pow(10, float64(k)), -math.Pow(10, float64(k)))
+	}
+	for _, p := range candidates {
+		if (v < p) != (s < convertNumber(p)) {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
+// Returns the type and value of a value, given as its string s, its number v, whether it is less than
+// its dumpPivot and whether !x is true for it. A value that is compared as a number is a number when its
+// string is that of the number, as for the integer sums passed to END exactly, and a string otherwise,
+// as for the fields that look like numbers but are written differently.
+func typedValue(name string, s string, v float64, less bool, not bool) dumpedValue {
+	numeric := not
+	if p, ok := dumpPivot(s, v); ok {
+		numeric = less == (v < p)
+	}
+	exact, ok := exactIntegers[name]
+	if numeric && (s == convertNumber(v) || ok && s == exact.String()) {
+		return dumpedValue{kind: "number", str: s, num: v}
+	}
+	return dumpedValue{kind: "string", str: s}
+}
+
+// Records the value of a scalar computed by the reduction
+func dumpNumber(name string, v float64) {
+	if dump.numbers == nil {
+		dump.numbers = make(map[string]float64)
+	}
+	dump.numbers[name] = v
+}
+
+// Records the elements of an array merged by the reduction
+func dumpArray(name string, values map[string]float64) {
+	if dump.values == nil {
+		dump.values = make(map[string]map[string]float64)
+	}
+	dump.values[name] = values
+}
+
+// Records the values of the scalars returned by the interpreter after executing a program
+func dumpGlobals(globals map[string]float64) {
+	for name, v := range globals {
+		if dump.scalars[name] {
+			dumpNumber(name, v)
+		}
+	}
+}
+
+// Records the elements of the arrays of a program, which the interpreter keeps in the maps it is given
+// by the index the parser gave every array
+func dumpArrays(prog *parser.Program, arrays map[int]map[string]float64) {
+	for name, index := range prog.Arrays {
+		if values, ok := arrays[index]; ok && dump.arrays[name] && len(values) > 0 {
+			dumpArray(name, values)
+		}
+	}
+}
+
+// Formats a number as gawk's --dump-variables does, with 17 significant digits, using the exact value
+// of an integer sum when END has not changed it
+func dumpValue(name string, v float64) string {
+	if exact, ok := exactIntegers[name]; ok {
+		if f, _ := new(big.Float).SetInt(exact).Float64(); f == v {
+			return exact.String()
+		}
+	}
+	return fmt.Sprintf("%.17g", v)
+}
+
+// Returns the type and value of a variable, as recorded by the END block of dumpProbe, or else the number
+// computed by the reduction. Reports false when neither is known.
+func dumpedVariable(name string) (dumpedValue, bool) {
+	if v, ok := dump.typed[name]; ok {
+		return v, true
+	}
+	if v, ok := dump.numbers[name]; ok {
+		return dumpedValue{kind: "number", num: v}, true
+	}
+	return dumpedValue{kind: "untyped"}, false
+}
+
+// Returns the elements of an array, as recorded by the END block of dumpProbe when it has been executed,
+// or else as merged by the reduction
+func dumpedElements(name string) map[string]dumpedValue {
+	if dump.convfmt != "" {
+		return dump.elements[name]
+	}
+	elements := make(map[string]dumpedValue)
+	for k, v := range dump.values[name] {
+		elements[k] = dumpedValue{kind: "number", num: v}
+	}
+	return elements
+}
+
+// Writes the global variables to dumpFile, in the format of gawk's --dump-variables, or as JSON
+// with --dump-format json. Variables are sorted by name, together with the built-in variables.
+func writeDump() {
+	if dumpFile == "" || dump.written {
+		return
+	}
+	dump.written = true
+
+	variables := make(map[string]dumpedValue)
+	for name := range dump.scalars {
+		variables[name], _ = dumpedVariable(name)
+	}
+	for _, name := range dumpBuiltins {
+		if v, ok := dumpedVariable(name); ok {
+			variables[name] = v
+		}
+	}
+	// the separators are known even when the END block of dumpProbe has not been executed, e.g. after exit
+	for _, s := range []struct{ name, value string }{{"FS", fieldSeparator}, {"OFS", offsetFieldSeparator}, {"RS", recordSeparator}} {
+		if _, ok := variables[s.name]; !ok {
+			variables[s.name] = dumpedValue{kind: "string", str: s.value}
+		}
+	}
+	var names []string
+	for name := range variables {
+		names = append(names, name)
+	}
+	for name := range dump.arrays {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if dumpFormat == "json" {
+		type variable struct {
+			Type  string      `json:"type"`
+			Value interface{} `json:"value,omitempty"`
+		}
+		jsonValue := func(name string, v dumpedValue) interface{} {
+			if v.kind == "number" && !math.IsNaN(v.num) && !math.IsInf(v.num, 0) {
+				return json.Number(dumpValue(name, v.num))
+			} else if v.kind == "number" {
+				return dumpValue(name, v.num)
+			}
+			return v.str
+		}
+		out := make(map[string]variable)
+		for _, name := range names {
+			if dump.arrays[name] {
+				elements := make(map[string]interface{})
+				for k, v := range dumpedElements(name) {
+					elements[k] = jsonValue(name+"["+k+"]", v)
+				}
+				out[name] = variable{"array", elements}
+			} else if v := variables[name]; v.kind == "untyped" {
+				out[name] = variable{Type: "untyped"}
+			} else {
+				out[name] = variable{v.kind, jsonValue(name, v)}
+			}
+		}
+		b, err := json.MarshalIndent(out, "", "  ")
+		check(err)
+		check(ioutil.WriteFile(dumpFile, append(b, '\n'), 0644))
+		return
+	}
+
+	var out strings.Builder
+	for _, name := range names {
+		out.WriteString(name + ": ")
+		v := variables[name]
+		switch {
+		case dump.arrays[name]:
+			out.WriteString("array, " + strconv.Itoa(len(dumpedElements(name))) + " elements")
+		case v.kind == "number":
+			out.WriteString(dumpValue(name, v.num))
+		case v.kind == "string":
+			out.WriteString(awkQuote(v.str))
+		default:
+			out.WriteString("untyped variable")
+		}
+		out.WriteString("\n")
+	}
+	check(ioutil.WriteFile(dumpFile, []byte(out.String()), 0644))
+}
+
+// Quotes a string as gawk's --dump-variables does, with the escape sequences of awk for quotes,
+// backslashes and control characters, and octal escapes for those that have none
+func awkQuote(s string) string {
+	escapes := map[byte]string{'"': `\"`, '\\': `\\`, '\a': `\a`, '\b': `\b`, '\f': `\f`, '\n': `\n`, '\r': `\r`, '\t': `\t`, '\v': `\v`}
+	var out strings.Builder
+	out.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if e, ok := escapes[c]; ok {
+			out.WriteString(e)
+		} else if c < ' ' || c == 0x7f {
+			out.WriteString(fmt.Sprintf("\\%03o", c))
+		} else {
+			out.WriteByte(c)
+		}
+	}
+	out.WriteByte('"')
+	return out.String()
+}
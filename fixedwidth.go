@@ -0,0 +1,238 @@
+// Copyright 2020 Georgios Theodorou
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// The native functions that the fields and NF are read with when the input is split in fixed-width fields
+const (
+	fieldFunction = "fixed_field"
+	nfFunction    = "fixed_nf"
+)
+
+type fieldWidth struct {
+	skip  int
+	width int // -1 stands for the rest of the record
+}
+
+var widths []fieldWidth
+
+// Parses a gawk FIELDWIDTHS value, e.g. "5 2:8 *". Every width may be preceded by the number of bytes to skip
+// before the field, and the last one may be * for a field that extends to the end of the record.
+// Returns nil when the value is empty, meaning that fields are split using FS.
+func parseFieldWidths(s string) []fieldWidth {
+	var fw []fieldWidth
+	items := strings.Fields(s)
+	for i, item := range items {
+		var w fieldWidth
+		var err error
+		if colon := strings.Index(item, ":"); colon >= 0 {
+			w.skip, err = strconv.Atoi(item[:colon])
+			if err != nil || w.skip < 0 {
+				panic("Invalid skip value in field widths: " + item)
+			}
+			item = item[colon+1:]
+		}
+		if item == "*" {
+			if i != len(items)-1 {
+				panic("Only the last field width can be *")
+			}
+			w.width = -1
+		} else {
+			w.width, err = strconv.Atoi(item)
+			if err != nil || w.width < 0 {
+				panic("Invalid field width: " + item)
+			}
+		}
+		fw = append(fw, w)
+	}
+	return fw
+}
+
+// Returns the end of the first record in b and the start of the record that follows it
+func nextRecord(b []byte) (int, int) {
+	if rsRegexp == nil {
+		i := bytes.IndexByte(b, recordSeparator[0])
+		if i < 0 {
+			return len(b), len(b)
+		}
+		return i, i + 1
+	}
+	loc := rsRegexp.FindIndex(b)
+	if loc == nil || loc[1] == loc[0] {
+		return len(b), len(b)
+	}
+	return loc[0], loc[1]
+}
+
+// Returns the start and end of every fixed-width field of a record. As in gawk, a record that is shorter
+// than the widths gets only the fields it reaches, while the bytes that are skipped or lie beyond the last
+// width do not belong to any field.
+func fixedWidthFields(record string) [][2]int {
+	var fields [][2]int
+	pos := 0
+	for _, w := range widths {
+		pos += w.skip
+		if pos >= len(record) {
+			break
+		}
+		end := pos + w.width
+		if w.width < 0 || end > len(record) {
+			end = len(record)
+		}
+		fields = append(fields, [2]int{pos, end})
+		pos = end
+	}
+	return fields
+}
+
+// Adds to funcs the functions that the program rewritten by rewriteFixedWidth reads the fields and NF with
+func addFixedWidthFunctions(funcs map[string]interface{}) {
+	if widths == nil {
+		return
+	}
+	funcs[fieldFunction] = func(record string, n int) string {
+		if n == 0 {
+			return record
+		}
+		fields := fixedWidthFields(record)
+		if n < 0 || n > len(fields) {
+			return ""
+		}
+		return record[fields[n-1][0]:fields[n-1][1]]
+	}
+	funcs[nfFunction] = func(record string) int {
+		return len(fixedWidthFields(record))
+	}
+}
+
+// Rewrites awk source so that it reads the fields, such as $2 or $(i+1), and NF with the functions of
+// addFixedWidthFunctions, which split $0 by the field widths. $0 itself is left as it was read, so that
+// printing it, its length and the regular expressions matched against it see the record of the input.
+// Returns false when the source assigns fields or NF, as the record is not rebuilt from them, in which
+// case splitFixedWidth has to be used instead.
+func rewriteFixedWidth(src string) (string, bool) {
+	if widths == nil {
+		return src, true
+	}
+	tokens := tokenize(src)
+	targets := make(map[int]bool)
+	for i, t := range tokens {
+		if t.kind == 'i' && (t.value == "sub" || t.value == "gsub") {
+			if target := subTarget(tokens[i+1:]); target >= 0 {
+				targets[i+1+target] = true
+			}
+		}
+	}
+	assigned := func(start int, end int) bool {
+		if end < len(tokens) && tokens[end].kind == 'o' && assignmentOps[tokens[end].value] || targets[start] {
+			return true
+		}
+		if start == 0 || tokens[start-1].value != "++" && tokens[start-1].value != "--" {
+			return false
+		}
+		// ++ and -- before the field increment it, unless they follow an operand they increment
+		return start == 1 || tokens[start-2].kind == 'o' && tokens[start-2].value != ")" && tokens[start-2].value != "]"
+	}
+
+	var out strings.Builder
+	last := 0
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.kind == 'i' && t.value == "NF":
+			if assigned(i, i+1) {
+				return src, false
+			}
+			out.WriteString(src[last:t.pos] + nfFunction + "($0)")
+			last = t.pos + len(t.value)
+		case t.kind == 'o' && t.value == "$" && i+1 < len(tokens):
+			end := operandEnd(tokens, i+1)
+			if end == i+2 && tokens[i+1].value == "0" {
+				continue
+			}
+			if assigned(i, end) {
+				return src, false
+			}
+			operandEnd := tokens[end-1].pos + len(tokens[end-1].value)
+			operand, ok := rewriteFixedWidth(src[tokens[i+1].pos:operandEnd])
+			if !ok {
+				return src, false
+			}
+			out.WriteString(src[last:t.pos] + fieldFunction + "($0, " + operand + ")")
+			last = operandEnd
+			i = end - 1
+		}
+	}
+	out.WriteString(src[last:])
+	return out.String(), true
+}
+
+// The function splitFixedWidth prefixes programs with, which sets the fields of the record to those
+// found by the field widths
+const splitFunction = "fixed_split"
+
+// Prefixes awk source that assigns fields or NF with a rule that splits every record by the field widths
+// before the actions, by assigning the fields of an empty record one by one. The program can then read and
+// assign the fields and NF as usual, but $0 becomes the fields joined by OFS, as after $1 = $1, so that
+// the bytes that are skipped or lie beyond the last width are lost. A record read by getline is split by FS.
+func splitFixedWidth(src string) string {
+	return "function " + splitFunction + "(record, i, n) {\n" +
+		"\tn = " + nfFunction + "(record)\n" +
+		"\t$0 = \"\"\n" +
+		"\tfor (i = 1; i <= n; i++) $i = " + fieldFunction + "(record, i)\n" +
+		"}\n" +
+		"{ " + splitFunction + "($0) }\n" + src
+}
+
+// Returns the index of the token following the operand of $ that starts at tokens[i]: a number, a variable,
+// an array element, a function call, a parenthesized expression or another field
+func operandEnd(tokens []token, i int) int {
+	t := tokens[i]
+	switch {
+	case t.kind == 'o' && (t.value == "$" || t.value == "++" || t.value == "--" || t.value == "-" || t.value == "+" || t.value == "!"):
+		if i+1 < len(tokens) {
+			return operandEnd(tokens, i+1)
+		}
+	case t.kind == 'o' && t.value == "(":
+		return closing(tokens, i)
+	case t.kind == 'i' && i+1 < len(tokens) && tokens[i+1].value == "[":
+		return closing(tokens, i+1)
+	case t.kind == 'i' && i+1 < len(tokens) && tokens[i+1].value == "(" && tokens[i+1].pos == t.pos+len(t.value):
+		return closing(tokens, i+1)
+	}
+	return i + 1
+}
+
+// Returns the index of the token following the bracket that closes the one at tokens[i]
+func closing(tokens []token, i int) int {
+	depth := 0
+	for j := i; j < len(tokens); j++ {
+		switch tokens[j].value {
+		case "(", "[":
+			depth++
+		case ")", "]":
+			depth--
+			if depth == 0 {
+				return j + 1
+			}
+		}
+	}
+	return len(tokens)
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 Georgios Theodorou
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Every byte of the fuzzed data is mapped to one of these characters, so that the input consists of
+// records with words and (possibly negative or decimal) numbers rather than arbitrary binary data
+const fuzzAlphabet = "abcxyz0123456789  \t\n-."
+
+// Returns a program made only of the reducible constructs pawk parallelizes, built from the seed:
+// up to three pattern-action lines accumulating into scalars or an array, and an END printing them
+func randomProgram(seed int64) string {
+	r := rand.New(rand.NewSource(seed))
+	patterns := []string{"", "$%d > 5 ", "NF % 2 == 0 ", "/%d/ "}
+	var actions, printed []string
+	hasArray := false
+	n := 1 + r.Intn(3)
+	for i := 0; i < n; i++ {
+		pattern := patterns[r.Intn(len(patterns))]
+		if strings.Contains(pattern, "%d") {
+			pattern = fmt.Sprintf(pattern, 1+r.Intn(3))
+		}
+		name := fmt.Sprintf("v%d", i)
+		field := 1 + r.Intn(3)
+		var stmt string
+		switch r.Intn(5) {
+		case 0:
+			stmt = fmt.Sprintf("%s += $%d", name, field)
+		case 1:
+			stmt = fmt.Sprintf("%s = %s + 1", name, name)
+		case 2:
+			stmt = fmt.Sprintf("%s = max(%s, $%d)", name, name, field)
+		case 3:
+			stmt = fmt.Sprintf("%s = min(%s, $%d)", name, name, field)
+		default:
+			stmt = fmt.Sprintf("x[$1] += $%d", field)
+			hasArray = true
+			name = ""
+		}
+		actions = append(actions, pattern+"{ "+stmt+" }")
+		if name != "" {
+			printed = append(printed, name)
+		}
+	}
+
+	var end []string
+	if len(printed) > 0 {
+		end = append(end, "print "+strings.Join(printed, ", "))
+	}
+	if hasArray {
+		end = append(end, `for (k in x) print k, x[k] | "sort"`)
+	}
+	return strings.Join(actions, "\n") + "\nEND { " + strings.Join(end, "; ") + " }\n"
+}
+
+// Asserts that the parallel execution of a program gives the output of its sequential execution, for varying
+// numbers of threads and chunk sizes, and therefore varying chunk boundaries. Sums over decimals are combined
+// with --summation exact and compared within the rounding error bound of sameOutput.
+func FuzzParallelMatchesSequential(f *testing.F) {
+	f.Add([]byte("a 1 2\nb 3 4\nc 5 6\n"), int64(0), uint8(2), uint8(1))
+	f.Add([]byte("x 10 -2.5\n\ny 3\tz 7\nx 1 1 1\n"), int64(1), uint8(3), uint8(4))
+	f.Add(bytes.Repeat([]byte("abc 123 4.5\nxyz -6 78\n"), 50), int64(2), uint8(4), uint8(8))
+
+	f.Fuzz(func(t *testing.T, data []byte, seed int64, threads uint8, size uint8) {
+		if len(data) == 0 {
+			t.Skip()
+		}
+		input := make([]byte, len(data))
+		for i, b := range data {
+			input[i] = fuzzAlphabet[int(b)%len(fuzzAlphabet)]
+		}
+
+		dir := t.TempDir()
+		inputFile := filepath.Join(dir, "input.txt")
+		progFile := filepath.Join(dir, "prog.awk")
+		prog := randomProgram(seed)
+		if err := ioutil.WriteFile(inputFile, input, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(progFile, []byte(prog), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		want := runPawk(t, []string{"--sequential"}, progFile, inputFile)
+		options := []string{
+			"-n", strconv.Itoa(1 + int(threads)%8), "--oversubscribe",
+			"--chunk-size", strconv.Itoa(1 + int(size)), "--summation", "exact",
+		}
+		got := runPawk(t, options, progFile, inputFile)
+		if !sameOutput(got, want, bytes.Count(input, []byte("\n"))+1, inputMagnitude(input)) {
+			t.Errorf("%s: parallel output differs from sequential\nprogram:\n%s\ninput:\n%q\nparallel:\n%s\nsequential:\n%s",
+				strings.Join(options, " "), prog, input, got, want)
+		}
+	})
+}
+
+// Returns an upper bound of the sum of the magnitudes of the numbers in the input, taking every run of digits
+// and points to be as large as a number with as many digits as it has before its first point
+func inputMagnitude(input []byte) float64 {
+	total := 0.0
+	digits, point, inRun := 0, false, false
+	for _, c := range append(input, ' ') {
+		switch {
+		case c >= '0' && c <= '9':
+			inRun = true
+			if !point {
+				digits++
+			}
+		case c == '.':
+			inRun, point = true, true
+		default:
+			if inRun {
+				total += math.Pow(10, float64(digits))
+			}
+			digits, point, inRun = 0, false, false
+		}
+	}
+	return total
+}
+
+// Reports whether the parallel output is the sequential one up to the rounding of the sums it prints.
+// Words must be equal, while numbers may differ by the error of summing the values of n records in floating
+// point, which is at most n·ε times the sum of their magnitudes for each of the two sums (the sequential one
+// and the exactly combined partial sums), plus the rounding of printing them with the 6 digits of OFMT.
+func sameOutput(got []byte, want []byte, n int, magnitude float64) bool {
+	gotLines := strings.Split(string(got), "\n")
+	wantLines := strings.Split(string(want), "\n")
+	if len(gotLines) != len(wantLines) {
+		return false
+	}
+	bound := 2 * float64(n) * math.Pow(2, -53) * magnitude
+	for i := range gotLines {
+		gotFields := strings.Fields(gotLines[i])
+		wantFields := strings.Fields(wantLines[i])
+		if len(gotFields) != len(wantFields) {
+			return false
+		}
+		for j := range gotFields {
+			if gotFields[j] == wantFields[j] {
+				continue
+			}
+			a, errA := strconv.ParseFloat(gotFields[j], 64)
+			b, errB := strconv.ParseFloat(wantFields[j], 64)
+			if errA != nil || errB != nil || math.Abs(a-b) > bound+1e-5*math.Max(math.Abs(a), math.Abs(b)) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Georgios Theodorou
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+var (
+	gcPercent   = ""
+	memoryLimit = ""
+)
+
+// Applies the garbage collection settings given from the console. When they are not given the Go runtime
+// defaults are kept (including GOGC and GOMEMLIMIT from the environment), except that inside a container
+// with a memory limit the soft memory limit is set to 90% of it, so that the collector works harder
+// before the container runs out of memory instead of after. These defaults are not yet based on the
+// results of BenchmarkGC.
+func setupGC() {
+	if gcPercent != "" {
+		if gcPercent == "off" {
+			debug.SetGCPercent(-1)
+		} else {
+			percent, err := strconv.Atoi(gcPercent)
+			if err != nil {
+				panic("Invalid garbage collection percentage: " + gcPercent)
+			}
+			debug.SetGCPercent(percent)
+		}
+	}
+
+	if memoryLimit != "" {
+		debug.SetMemoryLimit(parseSize(memoryLimit))
+	} else if os.Getenv("GOMEMLIMIT") == "" {
+		if limit := cgroupMemoryLimit(); limit > 0 {
+			debug.SetMemoryLimit(limit / 10 * 9)
+		}
+	}
+}
+
+// Parses a size such as 512MiB, 4G or 1000000 into bytes. Suffixes are binary multiples as in GOMEMLIMIT.
+func parseSize(s string) int64 {
+	units := []struct {
+		suffix string
+		size   int64
+	}{{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}}
+
+	number := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSpace(s), "B"), "i")
+	multiplier := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(strings.ToUpper(number), u.suffix) {
+			number = number[:len(number)-1]
+			multiplier = u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n <= 0 {
+		panic("Invalid size: " + s)
+	}
+	return n * multiplier
+}
+
+// Returns the memory limit of the cgroup of the process, or 0 if there is none
+func cgroupMemoryLimit() int64 {
+	for _, dir := range cgroupDirs("") {
+		if content, err := ioutil.ReadFile(filepath.Join(dir, "memory.max")); err == nil {
+			limit, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+			if err != nil {
+				// "max" means there is no limit
+				return 0
+			}
+			return limit
+		}
+	}
+	for _, dir := range cgroupDirs("memory") {
+		if content, err := ioutil.ReadFile(filepath.Join(dir, "memory.limit_in_bytes")); err == nil {
+			limit, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+			// without a limit cgroup v1 reports a number close to the maximum int64
+			if err != nil || limit >= 1<<62 {
+				return 0
+			}
+			return limit
+		}
+	}
+	return 0
+}
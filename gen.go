@@ -0,0 +1,147 @@
+// Copyright 2020 Georgios Theodorou
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pborman/getopt/v2"
+)
+
+// Describes the synthetic data written by pawk gen. Every line holds the word columns followed by
+// the numeric columns, separated by separator.
+type genSchema struct {
+	size         int64  // lines are written until the data reaches this many bytes
+	words        int    // the number of word columns
+	numbers      int    // the number of numeric columns
+	vocabulary   int    // the number of distinct words, drawn uniformly
+	distribution string // uniform, normal, exponential or zipf, the distribution of the numbers
+	max          int64  // numbers lie between 0 and max
+	decimals     int    // the digits after the decimal point of the numbers
+	separator    string
+	seed         int64 // the same seed always gives the same data
+}
+
+// The defaults resemble the data of text_files/filegen.py: a word and two numbers of up to 8 digits
+func defaultGenSchema() genSchema {
+	return genSchema{
+		size:         1 << 20,
+		words:        1,
+		numbers:      2,
+		vocabulary:   10,
+		distribution: "uniform",
+		max:          99999999,
+		separator:    " ",
+		seed:         1,
+	}
+}
+
+// Returns a function drawing numbers between 0 and max from the given distribution
+func numberSource(r *rand.Rand, distribution string, max int64) func() float64 {
+	clamp := func(v float64) float64 {
+		return math.Max(0, math.Min(float64(max), v))
+	}
+	switch distribution {
+	case "uniform":
+		return func() float64 { return r.Float64() * float64(max) }
+	case "normal":
+		return func() float64 { return clamp(float64(max)/2 + r.NormFloat64()*float64(max)/6) }
+	case "exponential":
+		return func() float64 { return clamp(r.ExpFloat64() * float64(max) / 10) }
+	case "zipf":
+		zipf := rand.NewZipf(r, 1.1, 1, uint64(max))
+		return func() float64 { return float64(zipf.Uint64()) }
+	default:
+		panic("Unknown distribution: " + distribution)
+	}
+}
+
+// Writes synthetic data following the schema to w
+func generate(w io.Writer, s genSchema) error {
+	r := rand.New(rand.NewSource(s.seed))
+	vocabulary := make([]string, s.vocabulary)
+	for i := range vocabulary {
+		word := make([]byte, 5+r.Intn(11))
+		for j := range word {
+			word[j] = byte('a' + r.Intn(26))
+		}
+		vocabulary[i] = string(word)
+	}
+	number := numberSource(r, s.distribution, s.max)
+
+	out := bufio.NewWriter(w)
+	columns := make([]string, 0, s.words+s.numbers)
+	var written int64
+	for written < s.size {
+		columns = columns[:0]
+		for i := 0; i < s.words && len(vocabulary) > 0; i++ {
+			columns = append(columns, vocabulary[r.Intn(len(vocabulary))])
+		}
+		for i := 0; i < s.numbers; i++ {
+			v := number()
+			if s.decimals == 0 {
+				columns = append(columns, strconv.FormatInt(int64(v), 10))
+			} else {
+				columns = append(columns, strconv.FormatFloat(v, 'f', s.decimals, 64))
+			}
+		}
+		n, err := out.WriteString(strings.Join(columns, s.separator) + "\n")
+		if err != nil {
+			return err
+		}
+		written += int64(n)
+	}
+	return out.Flush()
+}
+
+// Implements pawk gen, which writes synthetic data for testing and benchmarking pawk
+func genCommand(args []string) {
+	s := defaultGenSchema()
+	size := "1MiB"
+	output := ""
+	set := getopt.New()
+	set.FlagLong(&size, "size", 's', "the size of the data, e.g. 64MiB")
+	set.FlagLong(&s.words, "words", 'w', "the number of word columns")
+	set.FlagLong(&s.numbers, "numbers", 'c', "the number of numeric columns")
+	set.FlagLong(&s.vocabulary, "vocabulary", 0, "the number of distinct words")
+	set.FlagLong(&s.distribution, "distribution", 'D', "the distribution of the numbers: uniform, normal, exponential or zipf")
+	set.FlagLong(&s.max, "max", 0, "the largest number")
+	set.FlagLong(&s.decimals, "decimals", 0, "the digits after the decimal point of the numbers")
+	set.FlagLong(&s.separator, "separator", 'F', "the column separator")
+	set.FlagLong(&s.seed, "seed", 0, "the seed of the random generator")
+	set.FlagLong(&output, "output", 'o', "the file to write to, by default the standard output")
+	set.Parse(append([]string{"pawk gen"}, args...))
+
+	s.size = parseSize(size)
+	s.separator = unescape(s.separator)
+	if s.vocabulary < 1 || s.max < 1 || s.words < 0 || s.numbers < 0 || s.decimals < 0 {
+		panic("Invalid schema for generated data")
+	}
+
+	w := io.Writer(os.Stdout)
+	if output != "" {
+		f, err := os.Create(output)
+		check(err)
+		defer f.Close()
+		w = f
+	}
+	check(generate(w, s))
+}
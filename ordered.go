@@ -0,0 +1,100 @@
+// Copyright 2020 Georgios Theodorou
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/gthd/goawk/interp"
+	"github.com/gthd/goawk/parser"
+)
+
+// Executes a program without input, writing its output to stdout and to the destinations of its redirections.
+// It is used for BEGIN and END of the programs whose records are processed in parallel with ordered output.
+func runWithoutInput(src string, funcs map[string]interface{}) {
+	src, redirected := rewriteRedirects(src)
+	prog, err, _ := parser.ParseProgram([]byte(src), &parser.ParserConfig{Funcs: funcs})
+	check(err)
+	var output bytes.Buffer
+	config := &interp.Config{
+		Stdin:  bytes.NewReader(nil),
+		Output: &output,
+		Error:  ioutil.Discard,
+		Vars:   awkVars(),
+		Funcs:  funcs,
+	}
+	_, err, globals := interp.ExecOneThread(prog, config, associativeArrays)
+	check(err)
+	writeOutput(output.Bytes(), redirected)
+	dumpGlobals(globals)
+	dumpArrays(prog, associativeArrays)
+}
+
+// Executes the BEGIN blocks of a program once, for their output, before the input is processed
+func runBegin(prog *parser.Program, funcs map[string]interface{}) {
+	var src strings.Builder
+	src.WriteString(functionSource(prog))
+	for _, block := range prog.Begin {
+		src.WriteString("BEGIN {\n" + block.String() + "}\n")
+	}
+	endPhase := startPhase("BEGIN")
+	runWithoutInput(src.String(), funcs)
+	check(stdout.Flush())
+	endPhase()
+}
+
+// Returns the source of the END blocks of a program, preceded by its functions
+func endSource(prog *parser.Program) string {
+	var src strings.Builder
+	src.WriteString(functionSource(prog))
+	for _, block := range prog.End {
+		src.WriteString("END {\n" + block.String() + "}\n")
+	}
+	return src.String()
+}
+
+// Executes a program whose records can be processed independently of each other, as found by isRecordLocal.
+// BEGIN is executed once for its output, the chunks are processed in parallel by threads that only keep
+// the variables set in BEGIN, and their output is written in input order, followed by the output of END.
+func runOrdered(prog *parser.Program, args []string, funcs map[string]interface{}) {
+	setupThreads()
+	statsPath("parallel, ordered output (" + strconv.Itoa(numberOfThreads) + " threads)")
+	begin := quietBegin(prog)
+
+	if len(prog.Begin) > 0 {
+		runBegin(prog, funcs)
+	}
+
+	var src strings.Builder
+	src.WriteString(functionSource(prog) + begin)
+	for _, action := range prog.Actions {
+		src.WriteString(action.String() + "\n")
+	}
+	rewritten, redirected := rewriteRedirects(src.String())
+	actions, err, _ := parser.ParseProgram([]byte(rewritten), &parser.ParserConfig{Funcs: funcs})
+	check(err)
+	processChunks(args, actions, funcs, true, redirected)
+
+	if len(prog.End) > 0 || dumpFile != "" {
+		endPhase := startPhase("END")
+		runWithoutInput(begin+endSource(prog)+dumpProbe(), funcs)
+		endPhase()
+	}
+	closeOutputs()
+	exit(0)
+}
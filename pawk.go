@@ -24,13 +24,9 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
-	"net/http"
-	_ "net/http/pprof"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
-	"runtime/debug"
 	"strconv"
 	"strings"
 
@@ -41,7 +37,9 @@ import (
 )
 
 type chunk struct {
-	buff []byte
+	index int
+	file  int
+	buff  []byte
 }
 
 func check(e error) {
@@ -54,29 +52,24 @@ var (
 	value                helper.Helper
 	numberOfThreads      int
 	numCores             int
-	numSockets           int
+	oversubscribe        bool
+	sequential           bool
 	fieldSeparator       = " "
+	recordSeparator      = "\n"
+	fieldWidths          = ""
+	rsRegexp             *regexp.Regexp
 	offsetFieldSeparator = " "
-	fileName             = ""
-	dumpFile             = ""
 	eventualAwkCommand   string
 	endStatement         string
 	nameSlice            []string
 	min                  float64
 	max                  float64
-	indexEnd             [][]int
-	emptyStmt            bool
 	text                 []byte
-	pp                   *parser.Program
-	hasEnd               bool
-	hasBegin             bool
 	bbb                  string
+	beginOutput          bool
 	associativeValues    map[string]map[string]float64
-	associativeValue     map[string]float64
 	associativeArrays    map[int]map[string]float64
-	arraysPerFile        map[int][]*received
 	ok                   bool
-	flag                 bool
 	actionStatement      string
 	okArray              []bool
 	actions              map[int]string
@@ -97,34 +90,38 @@ var (
 )
 
 type received struct {
+	index            int
 	results          []float64
 	functionNames    []string
 	associativeArray map[string]float64
+	output           *bytes.Buffer
+	records          int64
 }
 
 // Used to parse input arguments given by the user from console
 func init() {
 	getopt.FlagLong(&fieldSeparator, "field-separator", 'F', "the field separator")
-	getopt.FlagLong(&numberOfThreads, "threads", 'n', "the number of threads to be used")
-	getopt.FlagLong(&fileName, "progfile", 'f', "the file name")
-	getopt.FlagLong(&dumpFile, "dump-variables", 'd', "the file to print the global variables")
+	getopt.FlagLong(&numberOfThreads, "threads", 'n', "the number of threads to be used, by default one per available CPU core")
+	getopt.FlagLong(&oversubscribe, "oversubscribe", 0, "allow more threads than available CPU cores")
+	getopt.FlagLong(&pprofAddr, "pprof-addr", 0, "the address to serve pprof profiles on, e.g. localhost:6060")
+	getopt.FlagLong(&cpuProfile, "cpuprofile", 0, "the file to write a CPU profile to")
+	getopt.FlagLong(&memProfile, "memprofile", 0, "the file to write a memory profile to")
+	getopt.FlagLong(&traceFile, "trace", 0, "the file to write an execution trace to")
+	getopt.FlagLong(&gcPercent, "gc-percent", 0, "the garbage collection target percentage, or off (as GOGC)")
+	getopt.FlagLong(&memoryLimit, "memory-limit", 0, "the soft memory limit, e.g. 4GiB (as GOMEMLIMIT)")
+	getopt.FlagLong(&showProgress, "progress", 0, "show the progress of processing the input on stderr")
+	getopt.FlagLong(&showStats, "stats", 0, "print statistics of the run to stderr")
+	getopt.FlagLong(&sequential, "sequential", 0, "execute the command in one thread")
+	getopt.FlagLong(&summation, "summation", 0, "how partial sums are combined: ordered, kahan or exact")
+	getopt.FlagLong(&chunkSize, "chunk-size", 0, "the size of the chunks the input is divided into, e.g. 4MiB")
+	getopt.FlagLong(sourceFlag{file: true}, "progfile", 'f', "a file containing the awk program, may be repeated")
+	getopt.FlagLong(sourceFlag{file: false}, "source", 'e', "awk program source, may be repeated and mixed with -f")
+	getopt.FlagLong(&dumpFile, "dump-variables", 'd', "the file to write the global variables to, by default awkvars.out").SetOptional()
+	getopt.FlagLong(&dumpFormat, "dump-format", 0, "the format of the dumped variables: text or json")
 	getopt.FlagLong(&value, "string", 'v', "strings")
 	getopt.FlagLong(&offsetFieldSeparator, "offset-field-separator", 'o', "the offset field separator")
-}
-
-// Used when the awk command is provided inside a file rather than written in the console
-func getCommand(commandFile string) string {
-	command := ""
-	f, err := os.Open(commandFile) //open the file to process it
-	check(err)
-	finfo, err := f.Stat()
-	check(err)
-	fsize := int(finfo.Size())
-	buf := make([]byte, fsize)
-	bytesContained, err := f.Read(buf)
-	check(err)
-	command = string(buf[:bytesContained])
-	return command
+	getopt.FlagLong(&recordSeparator, "record-separator", 'R', "the record separator")
+	getopt.FlagLong(&fieldWidths, "field-widths", 0, "the widths of fixed-width fields, as in gawk's FIELDWIDTHS")
 }
 
 // Used to open a file for reading/writing operations
@@ -142,60 +139,36 @@ func getSize(file *os.File) int {
 	return filesize
 }
 
-// Returns the starting index and the ending index for all the print statements of the awk command
+// Returns the starting index and the ending index for all the print and printf statements of the awk command.
+// A statement ends at the semicolon, newline or closing brace that follows it outside parentheses.
 func returnBeginPrintIndices(statement string) ([]int, []int) {
-	var phrase = `print`
 	var startingIndex []int
 	var endingIndex []int
-	compiled := regexp.MustCompile(phrase)
-	index := compiled.FindAllStringIndex(statement, -1)
-	if len(index) > 0 {
-		for i := range index {
-			startingIndex = append(startingIndex, index[i][0])
-		}
-
-		for iter, b := range []byte(statement) {
-			if b == 59 {
-				endingIndex = append(endingIndex, iter)
-			}
+	tokens := tokenize(statement)
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i].kind != 'i' || tokens[i].value != "print" && tokens[i].value != "printf" {
+			continue
 		}
-
-		// checks whether the first ending index is after the first starting index
-
-		for {
-			if len(endingIndex) > 0 && endingIndex[0] < startingIndex[0] {
-				endingIndex = endingIndex[1:]
-			} else {
+		startingIndex = append(startingIndex, tokens[i].pos)
+		end, depth := len(statement), 0
+		for ; i+1 < len(tokens); i++ {
+			t := tokens[i+1]
+			if t.value == "(" {
+				depth++
+			} else if t.value == ")" {
+				depth--
+			} else if depth == 0 && t.kind == 'o' && (t.value == ";" || t.value == "}") {
+				end = t.pos
 				break
 			}
 		}
-
-		if len(endingIndex) == 0 {
-			endingIndex = append(endingIndex, len(statement))
-		} else if startingIndex[len(startingIndex)-1] > endingIndex[len(endingIndex)-1] {
-			endingIndex = append(endingIndex, len(statement))
-		}
-
-		// checks whether all ending indexes are after their respective starting indexes
-		var tracker = 0
-		var test []int
-
-		// Since ending Index should contain
-		endingIndex = endingIndex[:len(startingIndex)]
-
-		for i := 0; i < len(endingIndex); i++ {
-			if endingIndex[i] > startingIndex[tracker] {
-				tracker++
-				test = append(test, endingIndex[i])
-			}
-		}
-		endingIndex = test
-		return startingIndex, endingIndex
+		endingIndex = append(endingIndex, end)
 	}
 	return startingIndex, endingIndex
 }
 
 func helpFileReading(file *os.File, numberOfThreads int) (int, int) {
+	multiple = 0
 	memory := int(C.sysconf(C._SC_PHYS_PAGES)*C.sysconf(C._SC_PAGE_SIZE)) - 2500000000
 	subFileSize = int(memory / numberOfThreads)
 	for {
@@ -208,50 +181,82 @@ func helpFileReading(file *os.File, numberOfThreads int) (int, int) {
 	return defaultSize, multiple
 }
 
-var end int
-var bytesToRead int
-var index int64
+var carry int
 
-// Used to divide the file to n equal parts that will be fed to the n different processors running in parallel
-func divideFile(file *os.File, n int, defaultSize int, multiple int) []chunk {
+// Used to divide the file to n parts that will be fed to the n different processors running in parallel.
+// Every part ends right after a record separator, so that no record gets split between two processors,
+// and the last part of the last round extends to the end of the file.
+func divideFile(file *os.File, n int, defaultSize int, lastRound bool) []chunk {
 	chunk := make([]chunk, n)
+	offset, err := file.Seek(0, io.SeekCurrent)
+	check(err)
+	if offset == 0 {
+		carry = 0
+	}
 
 	for thread := 0; thread < n; thread++ {
+		if lastRound && thread == n-1 {
+			b, err := ioutil.ReadAll(file)
+			check(err)
+			chunk[thread].buff = b
+			break
+		}
 
-		//In this way we check that the chunk does not end just before new line
-		bytesToRead = defaultSize + (bytesToRead - end) + 1
+		//In this way the bytes left over by the previous chunk are read again by the current one
+		bytesToRead := defaultSize + carry
 
 		//the byte length that gets handled by every thread
 		b := make([]byte, bytesToRead)
-		io.ReadAtLeast(file, b, bytesToRead)
+		read, err := io.ReadFull(file, b)
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			check(err)
+		}
+		b = b[:read]
 
-		for i := bytesToRead - 1; i > 0; i-- {
-			if b[i] == 10 {
-				end = i
+		end := recordEnd(b, read < bytesToRead)
+		chunk[thread].buff = b[:end]
+		carry = len(b) - end
+		offset += int64(end)
+		_, err = file.Seek(offset, io.SeekStart)
+		check(err)
+	}
+	return chunk
+}
+
+// Returns the separator variables passed to every interpreter that pawk runs
+func awkVars() []string {
+	return []string{"OFS", offsetFieldSeparator, "FS", fieldSeparator, "RS", recordSeparator}
+}
+
+// Returns the variables passed to END, which sees in NR the number of records of the whole input
+func endVars(records int64) []string {
+	return append(awkVars(), "NR", strconv.FormatInt(records, 10))
+}
+
+// Returns the number of records of the input files, reading them a chunk at a time
+func countInput(files []string) int64 {
+	size := int(parseSize(chunkSize))
+	var records int64
+	for _, name := range files {
+		file := openFile(name)
+		for {
+			c := divideFile(file, 1, size, false)[0]
+			if len(c.buff) == 0 && carry == 0 {
 				break
 			}
+			records += countRecords(c.buff)
 		}
-		// fmt.Println(end-bytesToRead)
-		if thread > 0 {
-			//For all threads other than the first, start from position 1 to exclude \n at the beginning of each chunk
-			chunk[thread].buff = b[1:end]
-		} else if thread == 0 && multiple == 1 {
-			chunk[thread].buff = b[:end]
-		} else if thread == 0 {
-			chunk[thread].buff = b[1:end]
-		}
-		_, err := file.Seek(index+int64(end), 0)
-		index += int64(end)
-		check(err)
+		file.Close()
 	}
-	return chunk
+	return records
 }
 
 // Responsible for communicating with the goAwk dependency. Returns the parsed awk Command
-func goAwk(chunk []byte, prog *parser.Program, fieldSeparator string, offsetFieldSeparator string, funcs map[string]interface{}, threadID int) ([]float64, []string, map[string]float64) {
+func goAwk(chunk []byte, prog *parser.Program, funcs map[string]interface{}, threadID int, output io.Writer) ([]float64, []string, map[string]float64) {
 	config := &interp.Config{
 		Stdin:  bytes.NewReader(chunk),
-		Vars:   []string{"OFS", offsetFieldSeparator, "FS", fieldSeparator},
+		Output: output,
+		Vars:   awkVars(),
 		Funcs:  funcs,
 		Thread: threadID,
 	}
@@ -274,29 +279,6 @@ func isContained(s string, slice []string) bool {
 	return flag
 }
 
-func getNumCores() int {
-	out, _ := exec.Command("lscpu").Output()
-	outstring := strings.TrimSpace(string(out))
-	lines := strings.Split(outstring, "\n")
-	for _, line := range lines {
-		fields := strings.Split(line, ":")
-		if len(fields) < 2 {
-			continue
-		}
-		key := strings.TrimSpace(fields[0])
-		value := strings.TrimSpace(fields[1])
-		switch key {
-		case "Core(s) per socket":
-			t, _ := strconv.Atoi(value)
-			numCores = int(t)
-		case "Socket(s)":
-			t, _ := strconv.Atoi(value)
-			numSockets = int(t)
-		}
-	}
-	return numCores * numSockets
-}
-
 func getFunctions() map[string]interface{} {
 
 	funcs := map[string]interface{}{
@@ -325,27 +307,126 @@ func getFunctions() map[string]interface{} {
 	return funcs
 }
 
-func main() {
+// Executes the command in one thread over the whole input, followed by the END statement, and exits.
+// The path describes why the command could not be parallelized.
+func runOneThread(prog *parser.Program, args []string, funcs map[string]interface{}, path string) {
+	fmt.Fprintln(os.Stderr, "Command gets executed in one thread !")
+	statsPath(path)
+	for _, name := range args {
+		file := openFile(name)
+		defer file.Close()
+		fileIndex := statsFile(name)
+		start := len(text)
+		defaultSize, multiple = helpFileReading(file, 1)
+		for iter := 0; iter < multiple; iter++ {
+			text = append(text, divideFile(file, 1, defaultSize, iter == multiple-1)[0].buff...)
+		}
+		statsChunk(fileIndex, -1, text[start:], 0)
+	}
+	input := bytes.NewReader(text)
+	config := &interp.Config{
+		Stdin:  input,
+		Output: nil,
+		Error:  ioutil.Discard,
+		Vars:   awkVars(),
+		Funcs:  funcs,
+	}
+	// the interpreter keeps the arrays in the maps it is given, so that they can be handed to END and dumped
+	associativeArrays = make(map[int]map[string]float64)
+	for _, index := range prog.Arrays {
+		associativeArrays[index] = make(map[string]float64)
+	}
+	endPhase := startPhase("sequential")
+	_, err, globals := interp.ExecOneThread(prog, config, associativeArrays)
+	check(err)
+	endPhase()
+	dumpGlobals(globals)
+	dumpArrays(prog, associativeArrays)
+	end, err, _ := parser.ParseProgram([]byte(endSource(prog)+dumpProbe()), &parser.ParserConfig{Funcs: funcs})
+	check(err)
+	endArrays := make(map[int]map[string]float64)
+	for name, index := range end.Arrays {
+		endArrays[index] = make(map[string]float64)
+		if values, ok := associativeArrays[prog.Arrays[name]]; ok {
+			endArrays[index] = values
+		}
+	}
 
-	debug.SetGCPercent(1)
+	configEnd := &interp.Config{
+		Stdin:  input,
+		Output: nil,
+		Error:  ioutil.Discard,
+		Vars:   endVars(countRecords(text)),
+		Funcs:  funcs,
+	}
+
+	endPhase = startPhase("END")
+	_, err, globals = interp.ExecOneThread(end, configEnd, endArrays)
+	check(err)
+	endPhase()
+	dumpGlobals(globals)
+	dumpArrays(end, endArrays)
+	exit(0)
+}
+
+func main() {
 
-	go func() {
-		log.Println(http.ListenAndServe("localhost:6060", nil))
-	}()
+	// pawk gen writes synthetic data instead of executing an awk command
+	if len(os.Args) > 1 && os.Args[1] == "gen" {
+		genCommand(os.Args[2:])
+		return
+	}
 
 	getopt.Parse()
 	args := getopt.Args()
 
-	awkCommand := ""
-	if fileName == "" {
-		awkCommand = args[0]
-		args = args[1:]
-	} else {
-		awkCommand = getCommand(fileName)
-	}
+	setupGC()
+	startProfiling()
+	defer stopProfiling()
+	defer reportStats()
+	defer writeDump()
+	defer closeOutputs()
+
+	fieldSeparator = normalizeFieldSeparator(fieldSeparator)
+	offsetFieldSeparator = unescape(offsetFieldSeparator)
+	recordSeparator = unescape(recordSeparator)
+
+	awkCommand, args := readProgram(args)
 
 	values := value.ParseMultipleOptions()
 
+	// Separators given with -v are handled by pawk itself rather than being assigned in BEGIN,
+	// so that they apply to the input of every thread as well as to END
+	for i := 0; i < len(values); i++ {
+		eq := strings.Index(values[i], "=")
+		if eq < 0 {
+			continue
+		}
+		val := unescape(strings.Trim(values[i][eq+1:], `"`))
+		switch values[i][:eq] {
+		case "FS":
+			fieldSeparator = val
+		case "OFS":
+			offsetFieldSeparator = val
+		case "RS":
+			recordSeparator = val
+		case "FIELDWIDTHS":
+			fieldWidths = val
+		default:
+			continue
+		}
+		values = append(values[:i], values[i+1:]...)
+		i--
+	}
+	checkFieldSeparator(fieldSeparator)
+	checkSummation(summation)
+	checkDumpFormat(dumpFormat)
+	if dumpFile == "" && getopt.IsSet("dump-variables") {
+		dumpFile = "awkvars.out"
+	}
+	rsRegexp = compileRecordSeparator(recordSeparator)
+	widths = parseFieldWidths(fieldWidths)
+
 	// used for passing to the BEGIN statement the values given from console with -v option
 	var periodContextFmt = `[Bb][Ee][Gg][Ii][Nn]\s*{`
 	sent := regexp.MustCompile(periodContextFmt)
@@ -367,130 +448,80 @@ func main() {
 		newAwkCommand = awkCommand
 	}
 
-	// Handles variable assignment in BEGIN as well as print statement
-	// CANNOT have something like this BEGIN {print "cndckd" ; emp=1 ; print "kcndkc"}
-	// SHOULD BE BEGIN {print "cndckd" ; print "kcndkc" ; emp=1}
-	// OR BEGIN {emp=1 ; print "cndckd" ; print "kcndkc"}
-	if strings.Contains(newAwkCommand, "BEGIN") { //Is it only BEGIN ? Or it can be Begin ?
-		// beginStatement := prog.Begin[0].String()
-		hasBegin = true
-		beginStatement := newAwkCommand[:strings.Index(newAwkCommand, "}")+1]
-
-		printStartIndex, printEndIndex := returnBeginPrintIndices(beginStatement)
-
-		// If print exists in BEGIN
-		if len(printStartIndex) > 0 {
-			// checks that print operation have something to print
-			for i := 0; i < len(printEndIndex); i++ {
-				if printEndIndex[i]-printStartIndex[i] <= 1 {
-					panic("Wrong syntax! Print No " + strconv.Itoa(i+1) + " does not contain anything")
-				}
-			}
-
-			// builds new string that contains everything except print statements
-			var str strings.Builder
-			str.WriteString(beginStatement[:printStartIndex[0]])
-			for iter := 1; iter < len(printEndIndex); iter++ {
-				str.WriteString(beginStatement[printEndIndex[iter-1]:printStartIndex[iter]])
-			}
-			str.WriteString(beginStatement[printEndIndex[len(printEndIndex)-1]:])
-
-			mystring := str.String()
-
-			indexOfBegin := strings.Index(newAwkCommand, `}`)
-
-			if string(mystring[len(mystring)-1]) != "}" {
-				mystring = mystring + `}`
-			}
-
-			eventualAwkCommand = mystring + newAwkCommand[indexOfBegin+1:]
+	// Programs that process every record independently of the others are executed in parallel with ordered output
+	funcs := getFunctions()
+	addDumpFunctions(funcs)
+	config := &parser.ParserConfig{
+		Funcs: funcs,
+	}
+	program, err, _ := parser.ParseProgram([]byte(newAwkCommand), config)
+	check(err)
 
-			for iter := 0; iter < len(printEndIndex); iter++ {
-				printvariable := beginStatement[printStartIndex[iter]:printEndIndex[iter]]
-				if string(printvariable[6]) == "\"" && string(printvariable[len(printvariable)-1]) == "\"" {
-					fmt.Printf(" %s ", printvariable[7:len(printvariable)-1])
-				} else if string(printvariable[6]) == "\"" && string(printvariable[len(printvariable)-2]) == "\"" {
-					fmt.Printf(" %s ", printvariable[7:len(printvariable)-2])
-				} else {
-					panic("Not provided a valid argument to print in BEGIN statement")
-				}
-			}
+	// Field widths assigned a string in BEGIN, as in BEGIN { FIELDWIDTHS = "5 2 *" }, apply as if given with
+	// --field-widths. The program is parsed again to read the fields by their widths, or, when it assigns
+	// fields or NF, to split every record by them before its actions.
+	fw, fwAssigned, fwConstant := beginConstant(program, "FIELDWIDTHS")
+	if fwAssigned && !fwConstant {
+		panic("FIELDWIDTHS can only be assigned a string in BEGIN")
+	}
+	if fwAssigned {
+		widths = parseFieldWidths(fw)
+	}
+	if widths != nil {
+		addFixedWidthFunctions(funcs)
+		if rewritten, ok := rewriteFixedWidth(newAwkCommand); ok {
+			newAwkCommand = rewritten
+			awkCommand, _ = rewriteFixedWidth(awkCommand)
 		} else {
-			eventualAwkCommand = newAwkCommand
+			newAwkCommand = splitFixedWidth(newAwkCommand)
+			awkCommand = splitFixedWidth(awkCommand)
 		}
-	} else {
-		eventualAwkCommand = newAwkCommand
+		program, err, _ = parser.ParseProgram([]byte(newAwkCommand), config)
+		check(err)
 	}
-
-	// Remove the END statement, gets handled on its own at the end
-	if strings.Contains(newAwkCommand, "END") {
-		hasEnd = true
-		var regexstring = `[Ee][Nn][Dd]\s*{`
-		comp := regexp.MustCompile(regexstring)
-		indexEnd = comp.FindAllStringIndex(eventualAwkCommand, -1)
-		endStatement = eventualAwkCommand[indexEnd[0][0]:]
-		eventualAwkCommand = strings.ReplaceAll(eventualAwkCommand, endStatement, "")
+	dumpVariables(program)
+
+	// A record separator assigned a string in BEGIN, as in BEGIN { RS = "" }, divides the input as if given with -R,
+	// while one assigned in any other way is only known as the program runs, so the program needs one thread
+	rs, rsAssigned, rsConstant := beginConstant(program, "RS")
+	if rsAssigned && rsConstant {
+		recordSeparator = rs
+		rsRegexp = compileRecordSeparator(recordSeparator)
 	}
 
-	// Removes BEGIN and END Statements from the initial AWK command
-	init := eventualAwkCommand
-	if hasEnd && hasBegin {
-		bbb = eventualAwkCommand[strings.Index(eventualAwkCommand, "}")+1 : indexEnd[0][0]]
-	} else if hasEnd && !hasBegin {
-		bbb = eventualAwkCommand[:indexEnd[0][0]]
-	} else if hasBegin && !hasEnd {
-		bbb = eventualAwkCommand[strings.Index(eventualAwkCommand, "}")+1:]
-	} else {
-		bbb = eventualAwkCommand
+	// Executes the command in one thread when asked to, e.g. to compare with the parallel execution
+	if sequential {
+		runOneThread(program, args, funcs, "sequential (requested)")
+	}
+	if rsAssigned && !rsConstant {
+		runOneThread(program, args, funcs, "sequential (RS assigned by the program)")
+	}
+	if isRecordLocal(program) {
+		runOrdered(program, args, funcs)
 	}
 
-	// Gets the indexes of the print functions in the action statements
-	printStartIndex, printEndIndex := returnBeginPrintIndices(bbb)
-
-	// Responsible for removing print statements from action statement and creating a new awk command that does not include them
-	if len(printStartIndex) > 0 && !strings.Contains(eventualAwkCommand, "for") {
-
-		for i := 0; i < len(printEndIndex); i++ {
-			if printEndIndex[i]-printStartIndex[i] <= 1 {
-				panic("Wrong syntax! Print No " + strconv.Itoa(i+1) + " does not contain anything")
-			}
-		}
-
-		// builds new string that contains everything except print statements
-		var str strings.Builder
-		str.WriteString(bbb[:printStartIndex[0]])
-		for iter := 1; iter < len(printEndIndex); iter++ {
-			str.WriteString(bbb[printEndIndex[iter-1]:printStartIndex[iter]])
-		}
-		str.WriteString(bbb[printEndIndex[len(printEndIndex)-1]:])
-
-		mystring := str.String()
-
-		if string(mystring[len(mystring)-1]) != "}" {
-			mystring = mystring + `}`
-		}
-
-		if len(strings.TrimSpace(mystring)) == 2 {
-			emptyStmt = true
-		}
+	// BEGIN with side effects other than its output, such as getline or system(), is executed once, in one thread
+	if !isQuietBegin(program) {
+		runOneThread(program, args, funcs, "sequential (BEGIN with side effects)")
+	}
 
-		// Create new AWK command that does not contain the print statements
-		if hasBegin && hasEnd {
-			abc := eventualAwkCommand[:strings.Index(eventualAwkCommand, "}")+1]
-			def := eventualAwkCommand[indexEnd[0][0]:]
-			eventualAwkCommand = abc + mystring + def
-		} else if hasBegin && !hasEnd {
-			abc := eventualAwkCommand[:strings.Index(eventualAwkCommand, "}")+1]
-			eventualAwkCommand = abc + mystring
-		} else if !hasBegin && hasEnd {
-			def := newAwkCommand[indexEnd[0][0]:]
-			eventualAwkCommand = mystring + def
-		} else if !hasBegin && !hasEnd {
-			eventualAwkCommand = mystring
+	// The threads execute BEGIN without its print statements, as given by quietBegin, and the whole of BEGIN
+	// is executed once for its output. END is executed on its own after the reduction.
+	for _, block := range program.Begin {
+		for _, stmt := range block {
+			beginOutput = beginOutput || isOutputStmt(stmt.String())
 		}
-	} else {
-		eventualAwkCommand = init
 	}
+	var body strings.Builder
+	for _, action := range program.Actions {
+		body.WriteString(action.String() + "\n")
+	}
+	bbb = body.String()
+	endStatement = endSource(program)
+	eventualAwkCommand = functionSource(program) + quietBegin(program) + bbb
+
+	// Gets the indexes of the print functions in the action statements
+	printStartIndex, _ := returnBeginPrintIndices(bbb)
 
 	// Responsible for distinguishing action statements in AWK commands that contains multiple blocks
 	actions = make(map[int]string)
@@ -526,169 +557,49 @@ func main() {
 		}
 	}
 
-	// Creates the config struct to be passed in GoAwk's Parser
-	funcs := getFunctions()
-	config := &parser.ParserConfig{
-		Funcs: funcs,
-	}
-
 	// Checks if an action statement contains an empty if operator, should be executed in one thread
 	for k := range actions {
 		if strings.Contains(actions[k], "{") && strings.Contains(actions[k], "}") {
 			actStatement := actions[k][strings.Index(actions[k], "{")+1 : strings.Index(actions[k], "}")]
 			if strings.Contains(actStatement, "if") {
 				if len(strings.TrimSpace(actStatement[strings.Index(actStatement, ")")+1:])) == 0 {
-					fmt.Println("Command gets executed in one thread !")
 					oneThreadProg, err, _ := parser.ParseProgram([]byte(awkCommand), config)
 					check(err)
-					for _, file := range args {
-						file := openFile(file)
-						defer file.Close()
-						defaultSize, multiple = helpFileReading(file, 1)
-						for iter := 0; iter < multiple; iter++ {
-							text = append(text, divideFile(file, 1, defaultSize, multiple)[0].buff...)
-						}
-					}
-					input := bytes.NewReader(text)
-					oneThreadConfig := &interp.Config{
-						Stdin:  input,
-						Output: nil,
-						Error:  ioutil.Discard,
-						Vars:   []string{"OFS", offsetFieldSeparator, "FS", fieldSeparator},
-						Funcs:  funcs,
-					}
-					_, err, _ = interp.ExecOneThread(oneThreadProg, oneThreadConfig, associativeArrays)
-					check(err)
-					end, err, _ := parser.ParseProgram([]byte(endStatement), nil)
-					check(err)
-
-					configEnd := &interp.Config{
-						Stdin:  input,
-						Output: nil,
-						Error:  ioutil.Discard,
-						Vars:   []string{"OFS", " ", "FS", " "},
-						Funcs:  funcs,
-					}
-
-					_, err, _ = interp.ExecOneThread(end, configEnd, associativeArrays)
-					check(err)
-					os.Exit(0)
+					runOneThread(oneThreadProg, args, funcs, "sequential (action with an empty if)")
 				}
 			}
 		}
 	}
 
-	prog, err, varTypes := parser.ParseProgram([]byte(eventualAwkCommand), config)
+	prog, err, _ := parser.ParseProgram([]byte(eventualAwkCommand), config)
 	check(err)
 
-	// Responsible for executing the print statements that exist in the action statement. Uses one thread since print cannot be parallelised
-	if len(printStartIndex) > 0 && len(prog.Actions) == 1 && !strings.Contains(eventualAwkCommand, "for") {
-		fmt.Println("Command gets executed in one thread !")
-		if len(prog.Actions) == 1 {
-			pp, err, _ = parser.ParseProgram([]byte(bbb), nil)
-			check(err)
-		} else {
-			pp, err, _ = parser.ParseProgram([]byte(bbb[printStartIndex[0]-1:printEndIndex[0]]), nil)
-			check(err)
-		}
-
-		for _, file := range args {
-			file := openFile(file)
-			defer file.Close()
-			defaultSize, multiple = helpFileReading(file, 1)
-			for iter := 0; iter < multiple; iter++ {
-				text = append(text, divideFile(file, 1, defaultSize, multiple)[0].buff...)
-			}
-		}
-
-		input := bytes.NewReader(text)
-
-		config := &interp.Config{
-			Stdin:  input,
-			Output: nil,
-			Error:  ioutil.Discard,
-			Vars:   []string{"OFS", offsetFieldSeparator, "FS", fieldSeparator},
-		}
-
-		_, err, _ = interp.ExecOneThread(pp, config, associativeArrays)
-		check(err)
-		end, err, _ := parser.ParseProgram([]byte(endStatement), nil)
-		check(err)
-
-		configEnd := &interp.Config{
-			Stdin:  input,
-			Output: nil,
-			Error:  ioutil.Discard,
-			Vars:   []string{"OFS", " ", "FS", " "},
-			Funcs:  funcs,
-		}
+	// Responsible for executing the print statements that exist in the action statements. Uses one thread since print cannot be parallelised
+	// unless the program is record-local, whatever the number of actions and whether they loop.
+	// The whole command is executed, as the stripped one has lost the print statements of BEGIN.
+	if len(printStartIndex) > 0 {
+		runOneThread(program, args, funcs, "sequential (print in action)")
+	}
 
-		_, err, _ = interp.ExecOneThread(end, configEnd, associativeArrays)
-		check(err)
-		os.Exit(0)
+	// Actions reading FILENAME or the records before the current one, such as NR, are executed in one thread,
+	// as the threads only see their chunk of the input
+	if usesCrossRecord(program) {
+		runOneThread(program, args, funcs, "sequential (action depends on the input as a whole)")
 	}
 
 	funcnames := make([]string, 0, len(funcs))
 	for k := range funcs {
-		funcnames = append(funcnames, k)
-	}
-
-	// In case a command contains local arguments then it cannot be parallelized, so it gets executed in one thread
-	if len(varTypes) > 1 {
-		oneThreadProg, err, _ := parser.ParseProgram([]byte(awkCommand), config)
-		fmt.Println("Command gets executed in one thread !")
-		check(err)
-		for _, file := range args {
-			file := openFile(file)
-			defer file.Close()
-			defaultSize, multiple = helpFileReading(file, 1)
-			for iter := 0; iter < multiple; iter++ {
-				text = append(text, divideFile(file, 1, defaultSize, multiple)[0].buff...)
-			}
-		}
-		input := bytes.NewReader(text)
-		oneThreadConfig := &interp.Config{
-			Stdin:  input,
-			Output: nil,
-			Error:  ioutil.Discard,
-			Vars:   []string{"OFS", offsetFieldSeparator, "FS", fieldSeparator},
-			Funcs:  funcs,
-		}
-		_, err, _ = interp.ExecOneThread(oneThreadProg, oneThreadConfig, associativeArrays)
-		check(err)
-		end, err, _ := parser.ParseProgram([]byte(endStatement), nil)
-		check(err)
-
-		configEnd := &interp.Config{
-			Stdin:  input,
-			Output: nil,
-			Error:  ioutil.Discard,
-			Vars:   []string{"OFS", " ", "FS", " "},
-			Funcs:  funcs,
+		// the fields read by width and the functions recording the dumped variables are not reductions
+		if k == fieldFunction || k == nfFunction || strings.HasPrefix(k, "dump_") {
+			continue
 		}
-
-		_, err, _ = interp.ExecOneThread(end, configEnd, associativeArrays)
-		check(err)
-		os.Exit(0)
+		funcnames = append(funcnames, k)
 	}
 
-	// Used for creating the dump file in case the -d option is passed. Unlike gawk in case -d not provided with file then the dump file is not  written
-	if dumpFile != "" {
-		dumpFile = `text_files/` + dumpFile
-		if _, err := os.Stat(dumpFile); err == nil {
-			err := os.Remove(dumpFile)
-			check(err)
-		}
-		dfile, err := os.OpenFile(dumpFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-		check(err)
-		defer dfile.Close()
-		for k := range varTypes[""] {
-			if k == "ARGV" {
-				continue
-			}
-			_, err = dfile.Write([]byte(k + "\n"))
-			check(err)
-		}
+	// Functions that only use their arguments and the current record are executed by every thread as part of
+	// the actions, while a command whose actions call functions with global state is executed in one thread
+	if callsStatefulFunction(prog) {
+		runOneThread(program, args, funcs, "sequential (function with global state)")
 	}
 
 	// Used for ensuring that only accumulation and assignment operations are allowed in action statements.
@@ -749,42 +660,9 @@ func main() {
 
 		// If action statement does not contain a user defined function or an accumulation operation
 		if !ok && !strings.Contains(actionStatement, "print") {
-			fmt.Println("Command gets executed in one thread !")
 			oneThreadProg, err, _ := parser.ParseProgram([]byte(awkCommand), config)
 			check(err)
-			for _, file := range args {
-				file := openFile(file)
-				defer file.Close()
-				defaultSize, multiple = helpFileReading(file, 1)
-				for iter := 0; iter < multiple; iter++ {
-					text = append(text, divideFile(file, 1, defaultSize, multiple)[0].buff...)
-				}
-			}
-			input := bytes.NewReader(text)
-			oneThreadConfig := &interp.Config{
-				Stdin:  input,
-				Output: nil,
-				Error:  ioutil.Discard,
-				Vars:   []string{"OFS", offsetFieldSeparator, "FS", fieldSeparator},
-				Funcs:  funcs,
-			}
-			_, err, _ = interp.ExecOneThread(oneThreadProg, oneThreadConfig, associativeArrays)
-			check(err)
-
-			end, err, _ := parser.ParseProgram([]byte(endStatement), nil)
-			check(err)
-
-			configEnd := &interp.Config{
-				Stdin:  input,
-				Output: nil,
-				Error:  ioutil.Discard,
-				Vars:   []string{"OFS", " ", "FS", " "},
-				Funcs:  funcs,
-			}
-
-			_, err, _ = interp.ExecOneThread(end, configEnd, associativeArrays)
-			check(err)
-			os.Exit(0)
+			runOneThread(oneThreadProg, args, funcs, "sequential (action is not a reduction)")
 		}
 	}
 
@@ -802,16 +680,22 @@ func main() {
 		}
 	}
 
+	// The threads return the elements of all the arrays in one map, so the arrays can only be told apart,
+	// and merged, when the actions fill a single one
+	arrayNames := make(map[string]bool)
+	for _, v := range variable {
+		if i := strings.Index(v, "["); i > 0 {
+			arrayNames[v[:i]] = true
+		}
+	}
+	if len(arrayNames) > 1 {
+		runOneThread(program, args, funcs, "sequential (several arrays)")
+	}
+
 	// In case there is an action body
 	if len(prog.Actions) > 0 {
 		// Goroutines usage for allowing paralle processing.
-		numCores = getNumCores()
-		fmt.Println("Number of cores is:", numCores)
-		numCores = 8
-		if numberOfThreads > numCores {
-			fmt.Println("Number of threads surpasses available CPU cores. Reverting to " + strconv.Itoa(numCores) + " threads. (Equal to the maximum number of CPU cores)")
-			numberOfThreads = numCores
-		}
+		setupThreads()
 
 		dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
 		if err != nil {
@@ -824,42 +708,16 @@ func main() {
 		}
 		os.MkdirAll(dir, 0777)
 
-		array := make([]*received, numberOfThreads)
-		arraysPerFile := make(map[int][]*received)
-		l := 0
-		channel := make(chan *received)
-		for _, file := range args {
-			file := openFile(file)
-			defer file.Close()
-			defaultSize, multiple = helpFileReading(file, numberOfThreads)
-			for iter := 0; iter < multiple; iter++ {
-				chunks := divideFile(file, numberOfThreads, defaultSize, multiple)
-				// for _, c := range chunks {
-				// 	fmt.Println("BEGIN")
-				// 	fmt.Println(string(c.buff))
-				// 	fmt.Println("END")
-				// }
-				for i := 0; i < numberOfThreads; i++ {
-					go func(chunks []chunk, i int, r chan<- *received) {
-						chunk := chunks[i]
-						res, names, arrays := goAwk(chunk.buff, prog, fieldSeparator, offsetFieldSeparator, funcs, i)
-						got := &received{results: res, functionNames: names, associativeArray: arrays}
-						r <- got
-					}(chunks, i, channel)
-				}
-				for i := 0; i < numberOfThreads; i++ {
-					array[i] = <-channel
-				}
-				arraysPerFile[l] = array
-				array = make([]*received, numberOfThreads)
-				l++
-			}
+		statsPath("parallel (" + strconv.Itoa(numberOfThreads) + " threads)")
+		if beginOutput {
+			runBegin(program, funcs)
 		}
+		array := processChunks(args, prog, funcs, false, false)
 
 		// Performs the suitable Reduction
+		endPhase := startPhase("reduction")
 		mapOfVariables := make(map[string]float64)
-		for f := 0; f < l; f++ {
-			array = arraysPerFile[f]
+		if len(array) > 0 {
 			j := 0
 
 			if len(variable) > 0 {
@@ -889,11 +747,13 @@ func main() {
 							}
 							j++
 						} else {
+							var partials []float64
 							for _, ar := range array {
 								if len(ar.results) > 0 {
-									mapOfVariables[variable[i]] += ar.results[i]
+									partials = append(partials, ar.results[i])
 								}
 							}
+							mapOfVariables[variable[i]] += reduceSum(variable[i], partials)
 						}
 					}
 				} else {
@@ -912,27 +772,33 @@ func main() {
 			}
 
 			if len(variable) > 0 {
-				if len(array[0].associativeArray) > 0 {
-					associativeValue = make(map[string]float64)
+				hasArrays := false
+				for _, ar := range array {
+					hasArrays = hasArrays || len(ar.associativeArray) > 0
+				}
+				if hasArrays {
 					associativeValues = make(map[string]map[string]float64)
 					r, _ := regexp.Compile("\\[[^\\]]*\\]")
 					for i := 0; i < len(variable); i++ {
 						match := r.MatchString(variable[i])
 						if match {
+							// an array updated by several statements, such as x[$1] and x[$2], is merged once
+							name := variable[i][:strings.Index(variable[i], "[")]
+							if _, merged := associativeValues[name]; merged {
+								continue
+							}
+							// the partial values of every key are summed in chunk order
+							partials := make(map[string][]float64)
 							for _, ar := range array {
 								for k := range ar.associativeArray {
-									associativeValue[k] += ar.associativeArray[k]
-									// associativeValues[variable[i]][k] += ar.associativeArray[k]
+									partials[k] = append(partials[k], ar.associativeArray[k])
 								}
 							}
-						} else {
-							if mapOfVariables[variable[i]] == float64(0) {
-								for _, ar := range array {
-									for k := range ar.associativeArray {
-										mapOfVariables[variable[i]] += ar.associativeArray[k]
-									}
-								}
+							merged := make(map[string]float64, len(partials))
+							for k := range partials {
+								merged[k] = reduceSum(name+"["+k+"]", partials[k])
 							}
+							associativeValues[name] = merged
 						}
 					}
 				}
@@ -944,29 +810,26 @@ func main() {
 			match := r.MatchString(variable[i])
 			if match {
 				variable[i] = variable[i][:strings.Index(variable[i], "[")]
-				associativeValues[variable[i]] = associativeValue
 			}
 		}
+		endPhase()
+		for name, v := range mapOfVariables {
+			dumpNumber(name, v)
+		}
+		for name, values := range associativeValues {
+			dumpArray(name, values)
+		}
 
-		end, err, _ := parser.ParseProgram([]byte(endStatement), nil)
+		// END sees the variables set in BEGIN, apart from those computed by the reduction
+		end, err, _ := parser.ParseProgram([]byte(beginExcept(program, variable)+endStatement+dumpProbe()), config)
 		check(err)
 
-		arrayKeys := make([]string, 0, len(end.Arrays))
-		for k := range end.Arrays {
-			arrayKeys = append(arrayKeys, k)
-		}
-
+		// The merged arrays are given to END under their own names, through the index the parser gave each array
 		associativeArrays = make(map[int]map[string]float64)
-
-		for i, k := range arrayKeys {
-			if k == "ARGV" {
-				associativeArrays[i] = make(map[string]float64)
-			} else {
-				for _, vf := range variable {
-					if vf == k {
-						associativeArrays[i] = associativeValues[k]
-					}
-				}
+		for k, index := range end.Arrays {
+			associativeArrays[index] = make(map[string]float64)
+			if values, ok := associativeValues[k]; ok && k != "ARGV" && isContained(k, variable) {
+				associativeArrays[index] = values
 			}
 		}
 
@@ -975,9 +838,11 @@ func main() {
 			keys = append(keys, k)
 		}
 
+		var reduced []string
 		for _, k := range keys {
 			if isContained(k, variable) {
 				end.Scalars[k] = mapOfVariables[k]
+				reduced = append(reduced, k)
 			} //else {
 			// 	panic("END Statement contains variables that have not been assigned!")
 			// 	toRemove = append(toRemove, k)
@@ -988,12 +853,16 @@ func main() {
 		// 	delete(end.Scalars, rem)
 		// }
 
+		var records int64
+		for _, ar := range array {
+			records += ar.records
+		}
 		input := bytes.NewReader([]byte(""))
 		configEnd := &interp.Config{
 			Stdin:  input,
 			Output: nil,
 			Error:  ioutil.Discard,
-			Vars:   []string{"OFS", " ", "FS", " "},
+			Vars:   append(endVars(records), exactVars(reduced)...),
 			Funcs:  funcs,
 		}
 
@@ -1005,6 +874,7 @@ func main() {
 			return nil
 		})
 		check(myErr)
+		sortByIndex(files)
 
 		for _, file := range files {
 			content, myErr2 := ioutil.ReadFile(file)
@@ -1013,23 +883,36 @@ func main() {
 			fmt.Println(printText)
 		}
 
-		_, err, _ = interp.ExecOneThread(end, configEnd, associativeArrays)
+		endPhase = startPhase("END")
+		_, err, globals := interp.ExecOneThread(end, configEnd, associativeArrays)
 		check(err)
+		endPhase()
+		dumpGlobals(globals)
+		dumpArrays(end, associativeArrays)
 		os.RemoveAll(dir)
 	} else {
-		end, err, _ := parser.ParseProgram([]byte(endStatement), nil)
+		end, err, _ := parser.ParseProgram([]byte(beginExcept(program, nil)+endStatement+dumpProbe()), config)
 		check(err)
-		input := bytes.NewReader([]byte("foo bar\n\nbaz buz"))
+		// END reads no input, the input files are only read to count their records for NR
+		input := bytes.NewReader([]byte(""))
 
 		configEnd := &interp.Config{
 			Stdin:  input,
 			Output: nil,
 			Error:  ioutil.Discard,
-			Vars:   []string{"OFS", " ", "FS", " "},
+			Vars:   endVars(countInput(args)),
 			Funcs:  funcs,
 		}
 
-		_, err, _ = interp.ExecOneThread(end, configEnd, associativeArrays)
+		statsPath("END only (no action statements)")
+		if beginOutput {
+			runBegin(program, funcs)
+		}
+		endPhase := startPhase("END")
+		_, err, globals := interp.ExecOneThread(end, configEnd, associativeArrays)
 		check(err)
+		endPhase()
+		dumpGlobals(globals)
+		dumpArrays(end, associativeArrays)
 	}
 }
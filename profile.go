@@ -0,0 +1,94 @@
+// Copyright 2020 Georgios Theodorou
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+var (
+	pprofAddr  = ""
+	cpuProfile = ""
+	memProfile = ""
+	traceFile  = ""
+	traceCtx   = context.Background()
+	stoppers   []func()
+)
+
+// Starts the profiling requested from the console. Nothing is started unless the respective option is given.
+func startProfiling() {
+	if pprofAddr != "" {
+		go func() {
+			log.Println(http.ListenAndServe(pprofAddr, nil))
+		}()
+	}
+
+	if cpuProfile != "" {
+		f, err := os.Create(cpuProfile)
+		check(err)
+		check(pprof.StartCPUProfile(f))
+		stoppers = append(stoppers, func() {
+			pprof.StopCPUProfile()
+			f.Close()
+		})
+	}
+
+	if traceFile != "" {
+		f, err := os.Create(traceFile)
+		check(err)
+		check(trace.Start(f))
+		// chunking, workers, reduction and END are recorded as regions of this task
+		ctx, task := trace.NewTask(context.Background(), "pawk")
+		traceCtx = ctx
+		stoppers = append(stoppers, func() {
+			task.End()
+			trace.Stop()
+			f.Close()
+		})
+	}
+
+	if memProfile != "" {
+		stoppers = append(stoppers, func() {
+			f, err := os.Create(memProfile)
+			check(err)
+			defer f.Close()
+			runtime.GC()
+			check(pprof.WriteHeapProfile(f))
+		})
+	}
+}
+
+// Stops the profiling started by startProfiling and writes the profiles to their files
+func stopProfiling() {
+	for _, stop := range stoppers {
+		stop()
+	}
+	stoppers = nil
+}
+
+// Used instead of os.Exit so that the dumped variables, the statistics and the profiles are written when pawk exits early
+func exit(code int) {
+	writeDump()
+	reportStats()
+	stopProfiling()
+	os.Exit(code)
+}
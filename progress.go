@@ -0,0 +1,111 @@
+// Copyright 2020 Georgios Theodorou
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+var showProgress bool
+
+// State of the progress line printed to stderr while the chunks are processed
+var progress struct {
+	sync.Mutex
+	enabled bool
+	total   int64
+	done    int64
+	start   time.Time
+	drawn   time.Time
+}
+
+// Checks whether the file is a terminal, so that the progress line is not written into redirected output.
+// Other character devices, such as /dev/null, are not terminals.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// Starts reporting the progress of processing the given files, if --progress was given and stderr is a terminal
+func startProgress(files []string) {
+	if !showProgress || !isTerminal(os.Stderr) {
+		return
+	}
+	progress.enabled = true
+	progress.start = time.Now()
+	for _, name := range files {
+		file := openFile(name)
+		progress.total += int64(getSize(file))
+		file.Close()
+	}
+}
+
+// Adds the bytes of a processed chunk to the progress, redrawing the progress line at most a few times per second
+func progressAdd(n int) {
+	if !progress.enabled {
+		return
+	}
+	progress.Lock()
+	defer progress.Unlock()
+	progress.done += int64(n)
+	if time.Since(progress.drawn) >= 200*time.Millisecond {
+		drawProgress()
+	}
+}
+
+// Draws the final state of the progress line and moves to the next line
+func finishProgress() {
+	if !progress.enabled {
+		return
+	}
+	progress.Lock()
+	defer progress.Unlock()
+	drawProgress()
+	fmt.Fprintln(os.Stderr)
+	progress.enabled = false
+}
+
+func drawProgress() {
+	progress.drawn = time.Now()
+	elapsed := progress.drawn.Sub(progress.start).Seconds()
+	line := formatBytes(float64(progress.done)) + " / " + formatBytes(float64(progress.total))
+	if progress.total > 0 {
+		line += fmt.Sprintf(" (%.1f%%)", 100*float64(progress.done)/float64(progress.total))
+	}
+	if elapsed > 0 && progress.done > 0 {
+		rate := float64(progress.done) / elapsed
+		line += ", " + formatBytes(rate) + "/s"
+		if remaining := progress.total - progress.done; remaining > 0 {
+			eta := time.Duration(float64(remaining) / rate * float64(time.Second))
+			line += ", ETA " + eta.Round(time.Second).String()
+		}
+	}
+	// \033[K clears what is left of a longer previous line
+	fmt.Fprintf(os.Stderr, "\rpawk: %s\033[K", line)
+}
+
+// Formats a number of bytes using binary units
+func formatBytes(b float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for b >= 1024 && i < len(units)-1 {
+		b /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", b, units[i])
+}
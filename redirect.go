@@ -0,0 +1,168 @@
+// Copyright 2020 Georgios Theodorou
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// The output of a redirected print or printf statement is written to stdout between redirectStart and
+// redirectStart, preceded by the mode (>, a for >> or |) and the destination ending with redirectDest.
+// The output is then written to its destination in input order by writeOutput.
+const (
+	redirectStart = '\x1c'
+	redirectDest  = '\x1d'
+)
+
+// An output file or the input of a command, opened the first time it is written to and shared by all the threads
+type destination struct {
+	w      *bufio.Writer
+	closer io.Closer
+	cmd    *exec.Cmd
+}
+
+var (
+	stdout       = bufio.NewWriter(os.Stdout)
+	destinations = make(map[string]*destination)
+	opened       []*destination
+)
+
+// Rewrites the print and printf statements of awk source that redirect their output with >, >> or |,
+// so that they write it to stdout marked with its destination. Reports whether any statement was rewritten,
+// as only then does the output contain marks to be parsed by writeOutput.
+func rewriteRedirects(src string) (string, bool) {
+	tokens := tokenize(src)
+	var out strings.Builder
+	last, rewritten := 0, false
+	for i := 0; i < len(tokens); i++ {
+		stmt := tokens[i]
+		if stmt.kind != 'i' || stmt.value != "print" && stmt.value != "printf" {
+			continue
+		}
+		depth, redirect, end := 0, -1, len(src)
+		for ; i+1 < len(tokens); i++ {
+			t := tokens[i+1]
+			if t.kind != 'o' {
+				continue
+			}
+			if t.value == "(" {
+				depth++
+			} else if t.value == ")" {
+				depth--
+			} else if depth == 0 && (t.value == ";" || t.value == "}") {
+				end = t.pos
+				break
+			} else if depth == 0 && redirect < 0 && (t.value == ">" || t.value == ">>" || t.value == "|") {
+				redirect = i + 1
+			}
+		}
+		if redirect < 0 {
+			continue
+		}
+		mode := map[string]string{">": ">", ">>": "a", "|": "|"}[tokens[redirect].value]
+		args := src[stmt.pos+len(stmt.value) : tokens[redirect].pos]
+		dest := src[tokens[redirect].pos+len(tokens[redirect].value) : end]
+		out.WriteString(src[last:stmt.pos])
+		out.WriteString(`{ printf "\034%s\035", "` + mode + `" (` + strings.TrimSpace(dest) + `); ` +
+			stmt.value + args + `; printf "\034" }`)
+		last, rewritten = end, true
+	}
+	out.WriteString(src[last:])
+	return out.String(), rewritten
+}
+
+// Writes output to stdout, and when it comes from a program with redirections the parts of it marked by
+// rewriteRedirects to their destinations. Output without redirections is written as it is, whatever bytes it holds.
+func writeOutput(b []byte, redirected bool) {
+	if !redirected {
+		_, err := stdout.Write(b)
+		check(err)
+		return
+	}
+	for len(b) > 0 {
+		start := bytes.IndexByte(b, redirectStart)
+		if start < 0 {
+			start = len(b)
+		}
+		_, err := stdout.Write(b[:start])
+		check(err)
+		if start == len(b) {
+			return
+		}
+		b = b[start+1:]
+		dest := bytes.IndexByte(b, redirectDest)
+		end := bytes.IndexByte(b, redirectStart)
+		if dest < 1 || end < dest {
+			panic("Redirected output is malformed, the input of a program with redirections cannot contain the bytes \\034 and \\035")
+		}
+		_, err = open(b[0], string(b[1:dest])).w.Write(b[dest+1 : end])
+		check(err)
+		b = b[end+1:]
+	}
+}
+
+// Returns the destination of a redirection, opening the file or starting the command the first time
+func open(mode byte, name string) *destination {
+	if d, ok := destinations[name]; ok {
+		return d
+	}
+	d := &destination{}
+	switch {
+	case name == "/dev/stdout":
+		d.w = stdout
+	case name == "/dev/stderr":
+		d.w = bufio.NewWriter(os.Stderr)
+	case mode == '|':
+		d.cmd = exec.Command("sh", "-c", name)
+		d.cmd.Stdout = os.Stdout
+		d.cmd.Stderr = os.Stderr
+		stdin, err := d.cmd.StdinPipe()
+		check(err)
+		check(d.cmd.Start())
+		d.w, d.closer = bufio.NewWriter(stdin), stdin
+	default:
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if mode == 'a' {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		file, err := os.OpenFile(name, flags, 0644)
+		check(err)
+		d.w, d.closer = bufio.NewWriter(file), file
+	}
+	destinations[name] = d
+	opened = append(opened, d)
+	return d
+}
+
+// Flushes stdout, then closes the files and the commands in the order they were opened, waiting for the commands to exit
+func closeOutputs() {
+	check(stdout.Flush())
+	for _, d := range opened {
+		check(d.w.Flush())
+		if d.closer != nil {
+			check(d.closer.Close())
+		}
+		if d.cmd != nil {
+			check(d.cmd.Wait())
+		}
+	}
+	destinations = make(map[string]*destination)
+	opened = nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 Georgios Theodorou
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"math"
+	"math/big"
+)
+
+// How the partial sums of the chunks are combined in the reduction. They are always given in chunk order,
+// so the plain "ordered" sum only depends on how the input was divided, "kahan" compensates for the rounding
+// errors of adding them up, and "exact" gives the correctly rounded sum, which does not depend on their order.
+var summation = "ordered"
+
+// Panics if the summation mode is not known
+func checkSummation(mode string) {
+	switch mode {
+	case "ordered", "kahan", "exact":
+	default:
+		panic("Unknown summation mode: " + mode + " (should be ordered, kahan or exact)")
+	}
+}
+
+// The exact values of the integer sums computed by the reduction, by variable name (and name[key] for arrays)
+var exactIntegers = make(map[string]*big.Int)
+
+// Sums the partial results of a variable. When all of them are integers, as with counters and byte totals,
+// they are added up exactly in int64, switching to big.Int on overflow, and only the total is converted
+// to float64, the type of awk numbers. Otherwise they are combined according to the summation mode.
+func reduceSum(name string, partials []float64) float64 {
+	if exact := integerSum(partials); exact != nil {
+		exactIntegers[name] = exact
+		f, _ := new(big.Float).SetInt(exact).Float64()
+		return f
+	}
+	return sumPartials(partials)
+}
+
+// Returns as variable assignments the integer sums of the given variables that a float64 cannot hold exactly.
+// Assigned as with -v, they are strings of the exact digits, which END prints as they are and converts
+// to numbers when computing with them.
+func exactVars(names []string) []string {
+	var vars []string
+	for _, name := range names {
+		exact, ok := exactIntegers[name]
+		if !ok {
+			continue
+		}
+		if _, accuracy := new(big.Float).SetInt(exact).Float64(); accuracy != big.Exact {
+			vars = append(vars, name, exact.String())
+		}
+	}
+	return vars
+}
+
+// Returns the exact sum of the values if they are all integers, or nil otherwise
+func integerSum(values []float64) *big.Int {
+	var small int64
+	var large *big.Int
+	for _, v := range values {
+		if math.IsInf(v, 0) || v != math.Trunc(v) {
+			return nil
+		}
+		if large == nil && math.Abs(v) < 1<<62 {
+			n := int64(v)
+			sum := small + n
+			// the sum overflows when both terms have the same sign and the sum has the opposite one
+			if (small >= 0) == (n >= 0) && (sum >= 0) != (small >= 0) {
+				large = big.NewInt(small)
+				large.Add(large, big.NewInt(n))
+				continue
+			}
+			small = sum
+			continue
+		}
+		if large == nil {
+			large = big.NewInt(small)
+		}
+		i, _ := new(big.Float).SetFloat64(v).Int(nil)
+		large.Add(large, i)
+	}
+	if large == nil {
+		return big.NewInt(small)
+	}
+	return large
+}
+
+// Returns the sum of the partial sums of the chunks according to the summation mode
+func sumPartials(partials []float64) float64 {
+	switch summation {
+	case "kahan":
+		return neumaierSum(partials)
+	case "exact":
+		return exactSum(partials)
+	}
+	return orderedSum(partials)
+}
+
+func orderedSum(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+// Neumaier's variant of Kahan summation, which also handles terms larger than the running sum
+func neumaierSum(values []float64) float64 {
+	sum, compensation := 0.0, 0.0
+	for _, v := range values {
+		t := sum + v
+		if math.Abs(sum) >= math.Abs(v) {
+			compensation += (sum - t) + v
+		} else {
+			compensation += (v - t) + sum
+		}
+		sum = t
+	}
+	return sum + compensation
+}
+
+// Adds the values without any rounding and rounds the result to the nearest float64 once.
+// The precision covers the whole exponent range of float64 plus room for carries.
+func exactSum(values []float64) float64 {
+	total := new(big.Float).SetPrec(2200)
+	for _, v := range values {
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			// infinities and NaN have no exact value, so the plain sum gives the awk result
+			return orderedSum(values)
+		}
+		total.Add(total, new(big.Float).SetFloat64(v))
+	}
+	f, _ := total.Float64()
+	return f
+}
@@ -0,0 +1,153 @@
+// Copyright 2020 Georgios Theodorou
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gthd/goawk/parser"
+)
+
+// The size of the chunks the input is divided into, given from the console. Having more chunks than threads
+// lets a thread that is done with its chunk take the next one, instead of waiting for a thread stuck with
+// a slow chunk. The chunks do not depend on the number of threads, so neither do the partial results
+// combined by the reduction.
+var chunkSize = "1MiB"
+
+// The number of chunks per thread that may be read but not yet done with, i.e. processed and, with ordered
+// output, written. It bounds the memory held when the chunks after a slow one are done before it.
+const chunksInFlight = 4
+
+// Divides the input files into chunks that are processed by a fixed pool of numberOfThreads goroutines,
+// every one of which takes the next chunk as soon as it is done with the previous one.
+// Chunks are indexed in input order and the results are returned in that order, whichever thread produced them.
+// When ordered is set, the output of every chunk is kept and written to stdout, or to the destinations of
+// its redirections when redirected is set, in input order, as soon as the output of all the chunks before it
+// has been written.
+func processChunks(files []string, prog *parser.Program, funcs map[string]interface{}, ordered bool, redirected bool) []*received {
+	size := int(parseSize(chunkSize))
+	jobs := make(chan chunk, numberOfThreads)
+	channel := make(chan *received, numberOfThreads)
+	slots := make(chan struct{}, numberOfThreads*chunksInFlight)
+
+	startProgress(files)
+	var wg sync.WaitGroup
+	for i := 0; i < numberOfThreads; i++ {
+		wg.Add(1)
+		go func(worker int, jobs <-chan chunk, r chan<- *received) {
+			defer wg.Done()
+			for c := range jobs {
+				region := trace.StartRegion(traceCtx, "worker")
+				start := time.Now()
+				var output *bytes.Buffer
+				var w io.Writer
+				if ordered {
+					output = new(bytes.Buffer)
+					w = output
+				}
+				res, names, arrays := goAwk(c.buff, prog, funcs, c.index, w)
+				statsChunk(c.file, worker, c.buff, time.Since(start))
+				progressAdd(len(c.buff))
+				region.End()
+				r <- &received{index: c.index, results: res, functionNames: names, associativeArray: arrays, output: output, records: countRecords(c.buff)}
+			}
+		}(i, jobs, channel)
+	}
+
+	// Only a few chunks are kept in memory at a time, as the next one is read when a slot is free
+	go func() {
+		index := 0
+		for _, name := range files {
+			file := openFile(name)
+			fileIndex := statsFile(name)
+			for {
+				slots <- struct{}{}
+				region := trace.StartRegion(traceCtx, "chunking")
+				c := divideFile(file, 1, size, false)[0]
+				region.End()
+				// nothing is left over only at the end of the file, otherwise the record is longer than a chunk
+				// and is read again together with the next one
+				if len(c.buff) == 0 {
+					<-slots
+					if carry == 0 {
+						break
+					}
+					continue
+				}
+				c.index = index
+				c.file = fileIndex
+				index++
+				jobs <- c
+			}
+			file.Close()
+		}
+		close(jobs)
+		wg.Wait()
+		close(channel)
+	}()
+
+	var array []*received
+	next := 0
+	for got := range channel {
+		for len(array) <= got.index {
+			array = append(array, nil)
+		}
+		array[got.index] = got
+		if !ordered {
+			<-slots
+		}
+		for ordered && next < len(array) && array[next] != nil {
+			writeOutput(array[next].output.Bytes(), redirected)
+			array[next].output = nil
+			next++
+			<-slots
+		}
+	}
+	finishProgress()
+	return array
+}
+
+// Sorts the output files written by the threads by the chunk index contained in their names,
+// so that 10 comes after 9 rather than after 1
+func sortByIndex(files []string) {
+	index := func(path string) (int, bool) {
+		name := filepath.Base(path)
+		start := 0
+		for start < len(name) && (name[start] < '0' || name[start] > '9') {
+			start++
+		}
+		end := start
+		for end < len(name) && name[end] >= '0' && name[end] <= '9' {
+			end++
+		}
+		n, err := strconv.Atoi(name[start:end])
+		return n, err == nil
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		a, okA := index(files[i])
+		b, okB := index(files[j])
+		if okA && okB && a != b {
+			return a < b
+		}
+		return files[i] < files[j]
+	})
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 Georgios Theodorou
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Replaces the escape sequences given from the console (e.g. -R '\0') with the characters they stand for.
+// Unknown sequences are kept as they are, so that regular expressions like '\.' still work.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var str strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			str.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'a':
+			str.WriteByte('\a')
+		case 'b':
+			str.WriteByte('\b')
+		case 'f':
+			str.WriteByte('\f')
+		case 'n':
+			str.WriteByte('\n')
+		case 'r':
+			str.WriteByte('\r')
+		case 't':
+			str.WriteByte('\t')
+		case 'v':
+			str.WriteByte('\v')
+		case '\\':
+			str.WriteByte('\\')
+		case '"', '/':
+			str.WriteByte(s[i])
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			// up to three octal digits, as in awk string literals
+			j := i
+			for j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7' {
+				j++
+			}
+			n, _ := strconv.ParseUint(s[i:j], 8, 8)
+			str.WriteByte(byte(n))
+			i = j - 1
+		default:
+			str.WriteByte('\\')
+			str.WriteByte(s[i])
+		}
+	}
+	return str.String()
+}
+
+// Normalizes the field separator given with -F. As in POSIX awk, -F t stands for a tab character,
+// while escape sequences like '\t' are replaced with the characters they stand for.
+func normalizeFieldSeparator(fs string) string {
+	if fs == "t" {
+		return "\t"
+	}
+	return unescape(fs)
+}
+
+// Panics if the field separator is not valid. A single space splits fields on runs of blanks, ignoring leading
+// and trailing ones, any other single character is used literally, and a longer separator is a regular expression.
+func checkFieldSeparator(fs string) {
+	if len(fs) > 1 {
+		if _, err := regexp.Compile(fs); err != nil {
+			panic("Invalid field separator: " + err.Error())
+		}
+	}
+}
+
+// Returns the regular expression that matches the record separator. A single character separator is matched
+// byte by byte instead, so nil is returned. An empty separator stands for paragraph mode, where records are
+// separated by one or more blank lines, while any longer separator is a regular expression as in gawk.
+func compileRecordSeparator(rs string) *regexp.Regexp {
+	switch {
+	case len(rs) == 1:
+		return nil
+	case rs == "":
+		return regexp.MustCompile(`\n\n+`)
+	default:
+		return regexp.MustCompile(rs)
+	}
+}
+
+// Returns the position right after the last complete record separator contained in b, or 0 if there is none.
+// A separator that touches the end of b may continue in the bytes that follow it, so it is not considered
+// complete unless the end of the file has been reached, in which case the whole of b is a record.
+func recordEnd(b []byte, atEOF bool) int {
+	if atEOF {
+		return len(b)
+	}
+	if rsRegexp == nil {
+		return bytes.LastIndexByte(b, recordSeparator[0]) + 1
+	}
+
+	// Only the tail of b is searched, doubling the window until a separator is found
+	for window := 4096; ; window *= 2 {
+		start := len(b) - window
+		if start < 0 {
+			start = 0
+		}
+		matches := rsRegexp.FindAllIndex(b[start:], -1)
+		for i := len(matches) - 1; i >= 0; i-- {
+			if matches[i][1] > matches[i][0] && start+matches[i][1] < len(b) {
+				return start + matches[i][1]
+			}
+		}
+		if start == 0 {
+			return 0
+		}
+	}
+}
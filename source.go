@@ -0,0 +1,124 @@
+// Copyright 2020 Georgios Theodorou
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pborman/getopt/v2"
+)
+
+// A part of the awk program, given either as a file with -f or as source text with -e
+type programSource struct {
+	file bool
+	text string
+}
+
+// The parts of the awk program in the order they were given on the command line
+var sources []programSource
+
+// A getopt value appending every occurrence of -f or -e to sources
+type sourceFlag struct {
+	file bool
+}
+
+func (f sourceFlag) Set(s string, _ getopt.Option) error {
+	sources = append(sources, programSource{file: f.file, text: s})
+	return nil
+}
+
+func (f sourceFlag) String() string {
+	return ""
+}
+
+// Used when the awk command is provided inside a file rather than written in the console
+func getCommand(commandFile string) string {
+	command, err := ioutil.ReadFile(commandFile)
+	check(err)
+	return string(command)
+}
+
+// Returns the awk program made of the -f files and the -e sources, concatenated in the order they were given
+// and separated by newlines. Without either, the program is the first argument, which is removed from args.
+// The @include directives of the program are replaced by the files they name.
+func readProgram(args []string) (string, []string) {
+	if len(sources) == 0 {
+		if len(args) == 0 {
+			panic("No awk program given. Provide it as the first argument, with -f progfile or with -e source")
+		}
+		return resolveIncludes(args[0], make(map[string]bool)), args[1:]
+	}
+	var program strings.Builder
+	for _, source := range sources {
+		if source.file {
+			program.WriteString(getCommand(source.text))
+		} else {
+			program.WriteString(source.text)
+		}
+		program.WriteString("\n")
+	}
+	return resolveIncludes(program.String(), make(map[string]bool)), args
+}
+
+// Replaces every @include "file" directive of the source with the contents of the file, found as with gawk
+// in the directories of AWKPATH. A file that has already been included is not included again.
+func resolveIncludes(src string, included map[string]bool) string {
+	tokens := tokenize(src)
+	var out strings.Builder
+	last := 0
+	for i := 0; i+2 < len(tokens); i++ {
+		if tokens[i].value != "@" || tokens[i+1].value != "include" || tokens[i+2].kind != 's' {
+			continue
+		}
+		path := findInclude(unescape(strings.Trim(tokens[i+2].value, `"`)))
+		out.WriteString(src[last:tokens[i].pos])
+		if !included[path] {
+			included[path] = true
+			out.WriteString(resolveIncludes(getCommand(path), included) + "\n")
+		}
+		last = tokens[i+2].pos + len(tokens[i+2].value)
+		i += 2
+	}
+	out.WriteString(src[last:])
+	return out.String()
+}
+
+// Returns the path of an included file. A name containing a slash is used as it is, any other is looked
+// for in the colon separated directories of AWKPATH, by default the current directory and /usr/local/share/awk,
+// also with the .awk suffix.
+func findInclude(name string) string {
+	if strings.Contains(name, "/") {
+		return name
+	}
+	awkPath := os.Getenv("AWKPATH")
+	if awkPath == "" {
+		awkPath = ".:/usr/local/share/awk"
+	}
+	for _, dir := range filepath.SplitList(awkPath) {
+		if dir == "" {
+			dir = "."
+		}
+		for _, candidate := range []string{name, name + ".awk"} {
+			path := filepath.Join(dir, candidate)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path
+			}
+		}
+	}
+	panic("Cannot find the included file " + name + " in AWKPATH")
+}
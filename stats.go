@@ -0,0 +1,150 @@
+// Copyright 2020 Georgios Theodorou
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime/trace"
+	"sync"
+	"time"
+)
+
+var showStats bool
+
+type fileStats struct {
+	name    string
+	bytes   int64
+	records int64
+}
+
+type workerStats struct {
+	chunks  int
+	records int64
+	busy    time.Duration
+}
+
+// Statistics of the run printed to stderr when --stats is given
+var stats struct {
+	sync.Mutex
+	path    string
+	files   []fileStats
+	chunks  int
+	workers []workerStats
+	phases  []string
+	times   map[string]time.Duration
+}
+
+// Returns the number of records contained in b
+func countRecords(b []byte) int64 {
+	if len(b) == 0 {
+		return 0
+	}
+	if rsRegexp == nil {
+		n := int64(bytes.Count(b, []byte{recordSeparator[0]}))
+		if b[len(b)-1] != recordSeparator[0] {
+			n++
+		}
+		return n
+	}
+	var n int64
+	for len(b) > 0 {
+		_, next := nextRecord(b)
+		b = b[next:]
+		n++
+	}
+	return n
+}
+
+// Records which execution path was chosen for the command
+func statsPath(path string) {
+	stats.Lock()
+	stats.path = path
+	stats.Unlock()
+}
+
+// Adds a file of the input to the statistics and returns its index
+func statsFile(name string) int {
+	stats.Lock()
+	defer stats.Unlock()
+	stats.files = append(stats.files, fileStats{name: name})
+	return len(stats.files) - 1
+}
+
+// Records that b, read from the file with the given index, has been processed by a worker in the given time.
+// Worker -1 stands for the sequential execution, which is not reported per worker.
+func statsChunk(file int, worker int, b []byte, busy time.Duration) {
+	if !showStats {
+		return
+	}
+	records := countRecords(b)
+	stats.Lock()
+	defer stats.Unlock()
+	stats.files[file].bytes += int64(len(b))
+	stats.files[file].records += records
+	if worker < 0 {
+		return
+	}
+	for len(stats.workers) <= worker {
+		stats.workers = append(stats.workers, workerStats{})
+	}
+	stats.chunks++
+	stats.workers[worker].chunks++
+	stats.workers[worker].records += records
+	stats.workers[worker].busy += busy
+}
+
+// Starts a phase of the execution, which is recorded as a region of the trace and timed for the statistics.
+// The returned function ends the phase.
+func startPhase(name string) func() {
+	region := trace.StartRegion(traceCtx, name)
+	start := time.Now()
+	return func() {
+		region.End()
+		elapsed := time.Since(start)
+		stats.Lock()
+		defer stats.Unlock()
+		if stats.times == nil {
+			stats.times = make(map[string]time.Duration)
+		}
+		if _, ok := stats.times[name]; !ok {
+			stats.phases = append(stats.phases, name)
+		}
+		stats.times[name] += elapsed
+	}
+}
+
+// Prints the statistics of the run to stderr if --stats was given
+func reportStats() {
+	if !showStats {
+		return
+	}
+	stats.Lock()
+	defer stats.Unlock()
+	fmt.Fprintf(os.Stderr, "pawk: execution path: %s\n", stats.path)
+	for _, f := range stats.files {
+		fmt.Fprintf(os.Stderr, "pawk: file %s: %d bytes, %d records\n", f.name, f.bytes, f.records)
+	}
+	if stats.workers != nil {
+		fmt.Fprintf(os.Stderr, "pawk: chunks: %d\n", stats.chunks)
+	}
+	for i, w := range stats.workers {
+		fmt.Fprintf(os.Stderr, "pawk: worker %d: %d chunks, %d records, %v\n", i, w.chunks, w.records, w.busy)
+	}
+	for _, name := range stats.phases {
+		fmt.Fprintf(os.Stderr, "pawk: %s: %v\n", name, stats.times[name])
+	}
+}
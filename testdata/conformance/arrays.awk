@@ -0,0 +1,2 @@
+{ area[$4] += $2; pop[$4] += $3 }
+END { for (k in area) print k, area[k], pop[k] | "sort" }
@@ -0,0 +1,2 @@
+$4 == "Asia" { n = n + 1 }
+END { print n }
@@ -0,0 +1,2 @@
+{ s += $2 }
+END { print s / NR }
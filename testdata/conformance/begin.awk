@@ -0,0 +1,2 @@
+BEGIN { print "COUNTRY", "AREA" }
+{ print $1, $2 }
@@ -0,0 +1,3 @@
+BEGIN { scale = 1000; unit = "km2" }
+{ area += $2 }
+END { print area * scale, unit }
@@ -0,0 +1,7 @@
+BEGIN {
+	n = 2 * 3
+	printf "%d columns, %s\n", n, toupper("area")
+	print "area" " total", n + 1
+}
+{ area += $2 }
+END { print area }
@@ -0,0 +1,3 @@
+BEGIN { getline first < "testdata/conformance/countries"; split(first, f, "\t") }
+{ n++ }
+END { print f[1], n }
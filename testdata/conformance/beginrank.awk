@@ -0,0 +1,2 @@
+BEGIN { print "RANK", "COUNTRY" }
+{ n++; print n, $1 }
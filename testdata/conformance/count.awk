@@ -0,0 +1 @@
+END { print NR }
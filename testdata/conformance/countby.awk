@@ -0,0 +1,5 @@
+{ n[$4]++ }
+END {
+	for (c in n)
+		print c, n[c] | "sort"
+}
@@ -0,0 +1 @@
+{ printf("%10s %6.1f\n", $1, 1000 * $3 / $2) }
@@ -0,0 +1,4 @@
+$3 > 100 {
+	for (i = NF; i > 0; i--)
+		printf "%s%s", $i, (i > 1 ? OFS : ORS)
+}
@@ -0,0 +1,2 @@
+{ area[FILENAME] += $2 }
+END { for (f in area) print f, area[f] }
@@ -0,0 +1 @@
+$3 > 100 { print FILENAME ": " $1 }
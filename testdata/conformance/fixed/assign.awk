@@ -0,0 +1,2 @@
+BEGIN { FIELDWIDTHS = "6 12 6 *"; OFS = "|" }
+{ $3 = $3 + 1; print }
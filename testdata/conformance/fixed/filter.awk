@@ -0,0 +1,2 @@
+BEGIN { FIELDWIDTHS = "6 12 6 *" }
+$3 + 0 > 20 { print $1 "|" $2 "|" NF "|" length($0) }
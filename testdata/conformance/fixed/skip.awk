@@ -0,0 +1,2 @@
+BEGIN { FIELDWIDTHS = "2 2:2 14:*" }
+{ print $1, $2, $3, NF }
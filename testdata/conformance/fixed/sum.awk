@@ -0,0 +1,3 @@
+BEGIN { FIELDWIDTHS = "6 12 6 *" }
+{ temp += $3; rain += $4; fields += NF }
+END { print temp, rain, fields }
@@ -0,0 +1,3 @@
+function count() { n++ }
+{ count() }
+END { print n }
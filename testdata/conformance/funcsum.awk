@@ -0,0 +1,3 @@
+function density(area, pop) { return 1000 * pop / area }
+$4 == "Asia" { total += density($2, $3) }
+END { printf "%.3f\n", total }
@@ -0,0 +1 @@
+{ gsub(/USA/, "United States"); print }
@@ -0,0 +1,3 @@
+@include "testdata/conformance/lib/pct.awk"
+
+$4 == "Asia" { print $1, pct($3, 2173) }
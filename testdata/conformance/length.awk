@@ -0,0 +1 @@
+length($1) > 5
@@ -0,0 +1,4 @@
+# Formats part as a percentage of total
+function pct(part, total) {
+	return sprintf("%.1f%%", 100 * part / total)
+}
@@ -0,0 +1 @@
+{ print NR ": " $0 }
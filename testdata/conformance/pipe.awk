@@ -0,0 +1,2 @@
+$3 > 100 { print $1, $3 | "sort -n -k 2" }
+END { print "total" | "sort -n -k 2" }
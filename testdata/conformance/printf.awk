@@ -0,0 +1 @@
+{ printf("%-10s %6d\n", $1, $3) }
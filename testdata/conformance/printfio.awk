@@ -0,0 +1,3 @@
+BEGIN { printf "%-14s %5s\n", "country", "pop" }
+$4 == "Asia" { printf "%-14s %5d\n", $1, $3 }
+END { printf "%s\n", "done" }
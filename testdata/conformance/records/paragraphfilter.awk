@@ -0,0 +1,2 @@
+BEGIN { RS = "" }
+$1 ~ /^[A-J]/ { print $1, $2, $NF }
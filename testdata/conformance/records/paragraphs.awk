@@ -0,0 +1,3 @@
+BEGIN { RS = "" }
+{ n++; fields += NF }
+END { print n, fields }
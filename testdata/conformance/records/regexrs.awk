@@ -0,0 +1,3 @@
+BEGIN { RS = "\n+" }
+{ n++; fields += NF }
+END { print n, fields }
@@ -0,0 +1,2 @@
+BEGIN { RS = "\n+" }
+/Street|Road/ { print $2, $3 }
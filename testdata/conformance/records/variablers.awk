@@ -0,0 +1,3 @@
+BEGIN { blank = ""; RS = blank }
+{ n++ }
+END { print n }
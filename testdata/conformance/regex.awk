@@ -0,0 +1 @@
+/^[A-C]/
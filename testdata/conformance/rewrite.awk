@@ -0,0 +1 @@
+{ $2 = $2 / 1000; print }
@@ -0,0 +1 @@
+{ n = split($4, words, " "); print $1, n }
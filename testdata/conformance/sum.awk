@@ -0,0 +1,2 @@
+{ pop = pop + $3 }
+END { print "total population", pop }